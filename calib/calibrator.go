@@ -0,0 +1,218 @@
+package calib
+
+import (
+	"errors"
+	"time"
+)
+
+// Timing and gains of the calibration. The hold controller keeps the
+// wheel on a target angle so the output it needs there is the cogging.
+const (
+	Settle = 150 * time.Millisecond // after moving to a bin
+	Sample = 100 * time.Millisecond // averaging the output at a bin
+	Pulse  = 30 * time.Millisecond  // torque pulse of the curve phase
+	Rest   = 300 * time.Millisecond // hold between pulses
+
+	holdP = 8  // output per count off target
+	holdI = 64 // output per count·s
+	holdD = 1  // output per 64 counts/s
+	maxI  = 4000
+)
+
+var ErrCurve = errors.New("calib: wheel did not move under torque")
+
+type phase uint8
+
+const (
+	phaseForward  phase = iota // sweeping up one turn
+	phaseBackward              // sweeping back down
+	phaseCurve                 // pulses of increasing torque
+	phaseDone
+)
+
+var phaseNames = [...]string{"cogging forward", "cogging backward", "torque curve", "done"}
+
+func (p phase) String() string {
+	return phaseNames[p]
+}
+
+// Calibrator runs the calibration one control loop tick at a time. It
+// needs the wheel free to turn one revolution in each direction.
+type Calibrator struct {
+	table Table
+	phase phase
+	err   error
+
+	start  int32 // angle the sweep started at
+	step   int   // bin or pulse being measured
+	bin    int   // bin being measured
+	target int32
+	since  time.Time
+	last   time.Time
+	integ  int32
+	sum    int64
+	n      int64
+
+	fwd, back [Bins]int32
+	accel     [CurvePoints * 2]int32 // counts moved by each pulse, + then -
+	from      int32                  // angle at the start of a pulse
+}
+
+// NewCalibrator starts a calibration with the wheel at angle.
+func NewCalibrator(angle int32) *Calibrator {
+	return &Calibrator{table: Identity(), start: angle, target: angle}
+}
+
+// Step takes the wheel angle in the direction of the output, the raw motor
+// angle the cogging follows, and the velocity in counts/s at now. It
+// returns the output for the tick and reports done once the table is
+// ready or the calibration failed.
+func (c *Calibrator) Step(now time.Time, pos, raw, vel int32) (out int32, done bool) {
+	if c.since.IsZero() {
+		c.since, c.last = now, now
+	}
+	switch c.phase {
+	case phaseForward, phaseBackward:
+		out = c.hold(now, pos, vel)
+		c.sweep(now, raw, out)
+	case phaseCurve:
+		out = c.curve(now, pos, raw, vel)
+	}
+	c.last = now
+	return out, c.phase == phaseDone
+}
+
+// hold returns the output keeping the wheel on target.
+func (c *Calibrator) hold(now time.Time, pos, vel int32) int32 {
+	e := c.target - pos
+	c.integ += int32(int64(holdI) * int64(e) * int64(now.Sub(c.last)) / int64(time.Second))
+	switch {
+	case c.integ > maxI:
+		c.integ = maxI
+	case c.integ < -maxI:
+		c.integ = -maxI
+	}
+	return holdP*e + c.integ - holdD*vel/64
+}
+
+// sweep moves the target one bin at a time and averages the hold output
+// at each bin once the wheel settled there.
+func (c *Calibrator) sweep(now time.Time, raw, out int32) {
+	t := now.Sub(c.since)
+	if t < Settle {
+		c.bin = bin(raw)
+		return
+	}
+	if t < Settle+Sample {
+		c.sum += int64(out)
+		c.n++
+		return
+	}
+	const size = CountsPerTurn / Bins
+	avg := int32(c.sum / c.n)
+	if c.phase == phaseForward {
+		c.fwd[c.bin] = avg
+		c.target += size
+	} else {
+		c.back[c.bin] = avg
+		c.target -= size
+	}
+	c.sum, c.n, c.since = 0, 0, now
+	c.step++
+	if c.step < Bins {
+		return
+	}
+	c.step = 0
+	if c.phase == phaseForward {
+		c.target -= size // back onto the last bin measured
+		c.phase = phaseBackward
+		return
+	}
+	// Friction adds to the hold output one way and subtracts the other,
+	// the mean of both sweeps leaves the cogging.
+	for i := range c.table.Cogging {
+		c.table.Cogging[i] = int16((c.fwd[i] + c.back[i]) / 2)
+	}
+	c.target = c.start
+	c.phase = phaseCurve
+}
+
+// bin returns the cogging bin of the raw motor angle.
+func bin(raw int32) int {
+	raw %= CountsPerTurn
+	if raw < 0 {
+		raw += CountsPerTurn
+	}
+	return int(raw / (CountsPerTurn / Bins))
+}
+
+// curve holds the wheel, then gives it a pulse of torque and records how
+// far it moved, which grows with the torque the motor put out.
+func (c *Calibrator) curve(now time.Time, pos, raw, vel int32) int32 {
+	const levels = CurvePoints - 1
+	t := now.Sub(c.since)
+	level, sign := int32(c.step%levels)+1, int32(1)
+	if c.step >= levels {
+		sign = -1
+	}
+	switch {
+	case t < Rest:
+		c.from = pos
+		return c.hold(now, pos, vel) + c.table.cogging(raw)
+	case t < Rest+Pulse:
+		return sign*level*CurveRange/levels + c.table.cogging(raw)
+	}
+	c.accel[c.step] = sign * (pos - c.from)
+	c.since = now
+	c.integ = 0
+	c.step++
+	if c.step == 2*levels {
+		c.finish()
+	}
+	return c.hold(now, pos, vel)
+}
+
+// finish inverts the measured curve. Torque is taken as proportional to
+// the distance a pulse moved the wheel, and the curve is scaled to match
+// the output at CurveRange.
+func (c *Calibrator) finish() {
+	const levels = CurvePoints - 1
+	var moved [CurvePoints]int32 // mean of both directions, index 0 at rest
+	for i := 1; i <= levels; i++ {
+		moved[i] = (c.accel[i-1] + c.accel[levels+i-1]) / 2
+		if moved[i] < moved[i-1] {
+			moved[i] = moved[i-1] // keep it monotonic
+		}
+	}
+	full := moved[levels]
+	c.phase = phaseDone
+	if full <= 0 {
+		c.err = ErrCurve
+		return
+	}
+	const step = CurveRange / levels
+	for i := 1; i < levels; i++ {
+		want := full * int32(i) / levels
+		j := 1
+		for j < levels && moved[j] < want {
+			j++
+		}
+		a, b := moved[j-1], moved[j]
+		out := int32(j-1) * step
+		if b > a {
+			out += step * (want - a) / (b - a)
+		}
+		c.table.Curve[i] = int16(out)
+	}
+	c.table.Curve[levels] = CurveRange
+}
+
+// Table returns the result of a finished calibration.
+func (c *Calibrator) Table() (Table, error) {
+	return c.table, c.err
+}
+
+// Progress returns the phase and how many steps of it are done.
+func (c *Calibrator) Progress() (string, int) {
+	return c.phase.String(), c.step
+}
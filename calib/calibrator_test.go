@@ -0,0 +1,64 @@
+package calib
+
+import (
+	"testing"
+)
+
+// finished returns a calibrator finished on the distances moved by the
+// pulses, the positive pulses moving fwd and the negative ones back.
+func finished(fwd, back [CurvePoints - 1]int32) *Calibrator {
+	c := NewCalibrator(0)
+	copy(c.accel[:], fwd[:])
+	copy(c.accel[CurvePoints-1:], back[:])
+	c.finish()
+	return c
+}
+
+func TestFinishInverts(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		fwd, back [CurvePoints - 1]int32
+		want      [CurvePoints]int16
+	}{
+		{"linear",
+			[...]int32{100, 200, 300, 400, 500, 600, 700, 800},
+			[...]int32{100, 200, 300, 400, 500, 600, 700, 800},
+			Identity().Curve},
+		// steep up to half the output, then flat: the low points are
+		// pulled in, the high ones pushed out
+		{"saturating",
+			[...]int32{200, 400, 600, 800, 850, 900, 950, 1000},
+			[...]int32{200, 400, 600, 800, 850, 900, 950, 1000},
+			[...]int16{0, 640, 1280, 1920, 2560, 3200, 3840, 5632, 8192}},
+		// friction takes from one direction what it gives the other
+		{"mean of directions",
+			[...]int32{250, 450, 650, 850, 900, 950, 1000, 1050},
+			[...]int32{150, 350, 550, 750, 800, 850, 900, 950},
+			[...]int16{0, 640, 1280, 1920, 2560, 3200, 3840, 5632, 8192}},
+		// a pulse moving less than the one before counts as much
+		{"non-monotonic",
+			[...]int32{100, 200, 150, 400, 500, 600, 700, 800},
+			[...]int32{100, 200, 150, 400, 500, 600, 700, 800},
+			[...]int16{0, 1024, 2048, 3584, 4096, 5120, 6144, 7168, 8192}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tb, err := finished(tc.fwd, tc.back).Table()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tb.Curve != tc.want {
+				t.Errorf("curve %v, want %v", tb.Curve, tc.want)
+			}
+		})
+	}
+}
+
+func TestFinishNoMovement(t *testing.T) {
+	c := finished([CurvePoints - 1]int32{}, [CurvePoints - 1]int32{})
+	if _, err := c.Table(); err != ErrCurve {
+		t.Errorf("got %v, want ErrCurve", err)
+	}
+	if phase, _ := c.Progress(); phase != "done" {
+		t.Errorf("phase %q, want done", phase)
+	}
+}
@@ -0,0 +1,143 @@
+// Package calib measures and compensates the cogging and the torque curve
+// of the motor.
+package calib
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+
+	"diy-ffb-wheel/settings"
+)
+
+const (
+	magic   = 0x43424646 // "FFBC"
+	version = 1
+
+	CountsPerTurn = 32768
+	Bins          = 128  // cogging bins per revolution
+	CurvePoints   = 9    // points of the torque curve from 0 to CurveRange
+	CurveRange    = 8192 // output the curve is measured up to
+)
+
+var (
+	ErrNoTable = errors.New("calib: no table stored")
+	ErrCorrupt = errors.New("calib: checksum mismatch")
+)
+
+// Table compensates the torque output. Cogging holds the output that
+// cancels the cogging at each bin of the motor angle. Curve holds the
+// output giving torques evenly spaced from 0 to CurveRange; past that the
+// output is offset by the last step.
+type Table struct {
+	Cogging [Bins]int16
+	Curve   [CurvePoints]int16
+}
+
+// Identity returns the table that leaves the output unchanged.
+func Identity() Table {
+	var t Table
+	for i := range t.Curve {
+		t.Curve[i] = int16(i * CurveRange / (CurvePoints - 1))
+	}
+	return t
+}
+
+// Apply returns the output giving torque out at the raw motor angle pos.
+func (t *Table) Apply(out, pos int32) int32 {
+	return t.linearize(out) + t.cogging(pos)
+}
+
+func (t *Table) linearize(out int32) int32 {
+	sign := int32(1)
+	if out < 0 {
+		sign, out = -1, -out
+	}
+	const step = CurveRange / (CurvePoints - 1)
+	if out >= CurveRange {
+		return sign * (out - CurveRange + int32(t.Curve[CurvePoints-1]))
+	}
+	i := out / step
+	a, b := int32(t.Curve[i]), int32(t.Curve[i+1])
+	return sign * (a + (b-a)*(out-i*step)/step)
+}
+
+func (t *Table) cogging(pos int32) int32 {
+	const size = CountsPerTurn / Bins
+	pos %= CountsPerTurn
+	if pos < 0 {
+		pos += CountsPerTurn
+	}
+	i := pos / size
+	a, b := int32(t.Cogging[i]), int32(t.Cogging[(i+1)%Bins])
+	return a + (b-a)*(pos-i*size)/size
+}
+
+// Entry returns value i of the table, cogging bins first, for reading it
+// out one value at a time.
+func (t *Table) Entry(i int) (int16, bool) {
+	switch {
+	case i < 0:
+	case i < Bins:
+		return t.Cogging[i], true
+	case i < Bins+CurvePoints:
+		return t.Curve[i-Bins], true
+	}
+	return 0, false
+}
+
+func (t Table) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 5+2*(Bins+CurvePoints)+4)
+	b = binary.LittleEndian.AppendUint32(b, magic)
+	b = append(b, version)
+	for _, v := range t.Cogging {
+		b = binary.LittleEndian.AppendUint16(b, uint16(v))
+	}
+	for _, v := range t.Curve {
+		b = binary.LittleEndian.AppendUint16(b, uint16(v))
+	}
+	b = binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+	return b, nil
+}
+
+func (t *Table) UnmarshalBinary(b []byte) error {
+	const n = 5 + 2*(Bins+CurvePoints)
+	if len(b) < n+4 || binary.LittleEndian.Uint32(b[0:4]) != magic || b[4] != version {
+		return ErrNoTable
+	}
+	if binary.LittleEndian.Uint32(b[n:]) != crc32.ChecksumIEEE(b[:n]) {
+		return ErrCorrupt
+	}
+	p := b[5:]
+	for i := range t.Cogging {
+		t.Cogging[i] = int16(binary.LittleEndian.Uint16(p))
+		p = p[2:]
+	}
+	for i := range t.Curve {
+		t.Curve[i] = int16(binary.LittleEndian.Uint16(p))
+		p = p[2:]
+	}
+	return nil
+}
+
+// Load reads the table from st. The Identity table is returned with the
+// error when nothing valid is stored.
+func Load(st settings.Storage) (Table, error) {
+	b := make([]byte, 5+2*(Bins+CurvePoints)+4)
+	if err := st.Load(b); err != nil {
+		return Identity(), err
+	}
+	var t Table
+	if err := t.UnmarshalBinary(b); err != nil {
+		return Identity(), err
+	}
+	return t, nil
+}
+
+func Save(st settings.Storage, t Table) error {
+	b, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return st.Save(b)
+}
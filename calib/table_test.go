@@ -0,0 +1,134 @@
+package calib
+
+import (
+	"errors"
+	"testing"
+
+	"diy-ffb-wheel/settings"
+)
+
+func TestIdentity(t *testing.T) {
+	id := Identity()
+	for _, out := range []int32{0, 1, 1000, -1000, CurveRange, -CurveRange, 20000, -20000} {
+		for _, pos := range []int32{0, 1234, -1234, 5 * CountsPerTurn} {
+			if got := id.Apply(out, pos); got != out {
+				t.Errorf("Apply(%d, %d) = %d, want %d", out, pos, got, out)
+			}
+		}
+	}
+}
+
+func TestLinearize(t *testing.T) {
+	// twice the output below CurveRange, offset by the last step past it
+	var tb Table
+	for i := range tb.Curve {
+		tb.Curve[i] = int16(2 * i * CurveRange / (CurvePoints - 1))
+	}
+	for _, tc := range []struct {
+		out, want int32
+	}{
+		{0, 0},
+		{512, 1024},
+		{-512, -1024},
+		{1024, 2048},
+		{1536, 3072},
+		{CurveRange - 1, 2*CurveRange - 2},
+		{CurveRange, 2 * CurveRange},
+		{CurveRange + 1000, 2*CurveRange + 1000},
+		{-CurveRange - 1000, -2*CurveRange - 1000},
+	} {
+		if got := tb.Apply(tc.out, 0); got != tc.want {
+			t.Errorf("Apply(%d) = %d, want %d", tc.out, got, tc.want)
+		}
+	}
+}
+
+func TestCogging(t *testing.T) {
+	tb := Identity()
+	tb.Cogging[0] = 0
+	tb.Cogging[1] = 256
+	tb.Cogging[Bins-1] = -256
+	const size = CountsPerTurn / Bins
+	for _, tc := range []struct {
+		pos, want int32
+	}{
+		{0, 0},
+		{size / 2, 128},
+		{size, 256},
+		{-size / 2, -128}, // between the last bin and the first
+		{CountsPerTurn + size/2, 128},
+		{-CountsPerTurn + size/2, 128},
+	} {
+		if got := tb.Apply(0, tc.pos); got != tc.want {
+			t.Errorf("cogging at %d = %d, want %d", tc.pos, got, tc.want)
+		}
+	}
+	// the cogging adds alike whatever the sign of the output
+	if got := tb.Apply(-1000, size); got != -744 {
+		t.Errorf("Apply(-1000, %d) = %d, want -744", size, got)
+	}
+}
+
+func testTable() Table {
+	tb := Identity()
+	for i := range tb.Cogging {
+		tb.Cogging[i] = int16(i*37 - 2000)
+	}
+	tb.Curve[3] = -1
+	return tb
+}
+
+func TestMarshal(t *testing.T) {
+	want := testTable()
+	b, _ := want.MarshalBinary()
+	var got Table
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestUnmarshalRejects(t *testing.T) {
+	tb := testTable()
+	for _, tc := range []struct {
+		name   string
+		modify func(b []byte) []byte
+		err    error
+	}{
+		{"short", func(b []byte) []byte { return b[:len(b)-1] }, ErrNoTable},
+		{"magic", func(b []byte) []byte { b[0]++; return b }, ErrNoTable},
+		{"version", func(b []byte) []byte { b[4]++; return b }, ErrNoTable},
+		{"cogging", func(b []byte) []byte { b[5] ^= 1; return b }, ErrCorrupt},
+		{"curve", func(b []byte) []byte { b[len(b)-6] ^= 0x80; return b }, ErrCorrupt},
+		{"checksum", func(b []byte) []byte { b[len(b)-1]++; return b }, ErrCorrupt},
+	} {
+		b, _ := tb.MarshalBinary()
+		got := tb
+		if err := got.UnmarshalBinary(tc.modify(b)); !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.err)
+		}
+		if got != tb {
+			t.Errorf("%s: table changed", tc.name)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	var m settings.Memory
+	if tb, err := Load(&m); err == nil || tb != Identity() {
+		t.Errorf("empty storage got %v, want the identity and an error", err)
+	}
+	m.Save(make([]byte, 5+2*(Bins+CurvePoints)+4))
+	if tb, err := Load(&m); !errors.Is(err, ErrNoTable) || tb != Identity() {
+		t.Errorf("blank storage got %v, want the identity and ErrNoTable", err)
+	}
+	want := testTable()
+	if err := Save(&m, want); err != nil {
+		t.Fatal(err)
+	}
+	if tb, err := Load(&m); err != nil || tb != want {
+		t.Errorf("got %v, %v, want the saved table", tb, err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"diy-ffb-wheel/calib"
+	"diy-ffb-wheel/control"
+	"diy-ffb-wheel/settings"
+)
+
+var (
+	// calStore keeps the calibration table in the erase block before the
+	// settings.
+	calStore    settings.Storage = settings.Flash{Block: 1}
+	table                        = calib.Identity()
+	calibrating *calib.Calibrator
+	tableIndex  int32 // entry of the table the vendor report reads
+)
+
+func loadTable() {
+	var err error
+	if table, err = calib.Load(calStore); err != nil {
+		log.Print(err)
+	}
+}
+
+// startCalibration measures the table anew. The wheel must be free to
+// turn one revolution each way.
+func startCalibration() {
+	if ctl.State() != control.StateRunning {
+		log.Printf("calibrate: wheel is %s, not running", ctl.State())
+		return
+	}
+	calibrating = calib.NewCalibrator(kin.Position)
+	log.Print("calibrate: started, let go of the wheel")
+}
+
+func cancelCalibration() {
+	if calibrating != nil {
+		calibrating = nil
+		log.Print("calibrate: canceled")
+	}
+}
+
+// compensate returns the output for a wheel at angle and the raw motor
+// angle raw, from the calibration in progress or through the table.
+func compensate(now time.Time, output, angle, raw int32) int32 {
+	if calibrating != nil && ctl.State() != control.StateRunning {
+		cancelCalibration()
+	}
+	if calibrating == nil {
+		return table.Apply(output, raw)
+	}
+	out, done := calibrating.Step(now, angle, raw, kin.Velocity)
+	if done {
+		finishCalibration()
+	}
+	return out
+}
+
+// finishCalibration stores the measured table.
+func finishCalibration() {
+	t, err := calibrating.Table()
+	calibrating = nil
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	table = t
+	saveWithTorqueOff(func() error { return calib.Save(calStore, table) })
+	log.Print("calibrate: done")
+}
+
+// printTable writes the table as lines of index and value, cogging bins
+// first.
+func printTable() {
+	for i := 0; ; i++ {
+		v, ok := table.Entry(i)
+		if !ok {
+			return
+		}
+		fmt.Printf("%d,%d\n", i, v)
+	}
+}
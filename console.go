@@ -0,0 +1,282 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"diy-ffb-wheel/pid"
+)
+
+// actions run on the control loop between ticks, so they may touch the
+// motor driver and the loop state without racing it.
+var actions = make(chan func(), 8)
+
+func post(f func()) {
+	select {
+	case actions <- f:
+	default:
+		log.Print("action queue full")
+	}
+}
+
+func runActions() {
+	for {
+		select {
+		case f := <-actions:
+			f()
+		default:
+			return
+		}
+	}
+}
+
+// calibrateCenter takes the current wheel position as the new center.
+func calibrateCenter() {
+	zero := drv.Position().Raw()
+	saveWithTorqueOff(func() error { return setCenter(zero) })
+	log.Printf("center: %d", zero)
+}
+
+// printStats writes the loop timing gathered since the last call.
+func printStats() {
+	if _, err := loop.WriteTo(os.Stdout); err != nil {
+		log.Print(err)
+	}
+	loop.Reset()
+}
+
+func setRate(rate int) {
+	if err := loop.SetRate(rate); err != nil {
+		log.Print(err)
+		return
+	}
+	loop.Reset()
+	streamFilter, hostFilter, outFilter = filterConfig.Build(loop.Rate())
+	log.Printf("rate: %d Hz", loop.Rate())
+}
+
+// clearFaults releases the latched safety trips.
+func clearFaults() {
+	log.Printf("safety: cleared %s", guard.Faults())
+	guard.Clear()
+}
+
+// isCommand reports whether a serial line is a console command rather than
+// a line of pedal and shifter values.
+func isCommand(line string) bool {
+	return len(line) > 0 && (line[0] >= 'a' && line[0] <= 'z')
+}
+
+func handleCommand(line string) {
+	args := strings.Fields(line)
+	switch args[0] {
+	case "center":
+		post(calibrateCenter)
+	case "clear":
+		post(clearFaults)
+	case "state":
+		post(func() {
+			log.Printf("state: %s since %v, cause: %s, safety: %s",
+				ctl.State(), ctl.Since().Format(time.StampMilli), ctl.Cause(), guard.Faults())
+		})
+	case "calibrate":
+		if len(args) > 1 && args[1] == "cancel" {
+			post(cancelCalibration)
+			return
+		}
+		post(startCalibration)
+	case "table":
+		post(printTable)
+	case "standby":
+		post(func() { ctl.Standby(time.Now()) })
+	case "resume":
+		post(func() { ctl.Resume(time.Now()) })
+	case "stats":
+		post(printStats)
+	case "rate":
+		if len(args) < 2 {
+			post(func() { log.Printf("rate: %d Hz", loop.Rate()) })
+			return
+		}
+		rate, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		post(func() { setRate(rate) })
+	case "damper", "friction", "inertia", "spring":
+		if len(args) < 2 {
+			log.Printf("usage: %s <percent>", args[0])
+			return
+		}
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		post(func() { setFeel(args[0], int32(v)) })
+	case "range", "profile":
+		if len(args) < 2 {
+			post(func() { log.Printf("profile: %d, range: %d deg", conf.Profile, lock2Lock()) })
+			return
+		}
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if args[0] == "range" {
+			post(func() { setRange(int32(v)) })
+		} else {
+			post(func() { selectProfile(int32(v)) })
+		}
+	default:
+		log.Printf("unknown command: %s", args[0])
+	}
+}
+
+const (
+	vendorCenter   = 1
+	vendorRange    = 2
+	vendorProfile  = 3
+	vendorFaults   = 4
+	vendorDamper   = 5
+	vendorFriction = 6
+	vendorInertia  = 7
+	vendorSpring   = 8
+	vendorState    = 9
+	vendorCalib    = 10
+	vendorTable    = 11
+)
+
+// vendorFeel names the natural effects by vendor command.
+var vendorFeel = map[uint8]string{
+	vendorDamper:   "damper",
+	vendorFriction: "friction",
+	vendorInertia:  "inertia",
+	vendorSpring:   "spring",
+}
+
+var vendorQuery uint8
+
+// vendorSet handles the vendor feature report written by the host. It is
+// called from the USB interrupt, so the command is run by the control loop.
+func vendorSet(v pid.VendorFeatureData) {
+	post(func() { vendorCommand(v) })
+}
+
+func vendorCommand(v pid.VendorFeatureData) {
+	vendorQuery = v.Command
+	switch v.Command {
+	case vendorCenter:
+		if v.Value != 0 {
+			calibrateCenter()
+		}
+	case vendorRange:
+		setRange(v.Value)
+	case vendorProfile:
+		selectProfile(v.Value)
+	case vendorFaults:
+		if v.Value == 0 {
+			clearFaults()
+		}
+	case vendorDamper, vendorFriction, vendorInertia, vendorSpring:
+		setFeel(vendorFeel[v.Command], v.Value)
+	case vendorCalib:
+		if v.Value != 0 {
+			startCalibration()
+		} else {
+			cancelCalibration()
+		}
+	case vendorTable:
+		tableIndex = v.Value
+	case vendorState:
+		if v.Value == 0 {
+			ctl.Standby(time.Now())
+		} else {
+			ctl.Resume(time.Now())
+		}
+	}
+}
+
+// vendorGet answers reads of the vendor feature report with the value of
+// the last command written.
+func vendorGet() pid.VendorFeatureData {
+	v := pid.VendorFeatureData{Command: vendorQuery}
+	switch vendorQuery {
+	case vendorCenter:
+		v.Value = conf.CenterOffset
+	case vendorRange:
+		v.Value = lock2Lock()
+	case vendorProfile:
+		v.Value = int32(conf.Profile)
+	case vendorFaults:
+		v.Value = int32(guard.Faults())
+	case vendorDamper:
+		v.Value = int32(conf.Active().Damper)
+	case vendorFriction:
+		v.Value = int32(conf.Active().Friction)
+	case vendorInertia:
+		v.Value = int32(conf.Active().Inertia)
+	case vendorSpring:
+		v.Value = int32(conf.Active().Spring)
+	case vendorState:
+		v.Value = int32(ctl.State()) | int32(ctl.Cause())<<8
+	case vendorCalib:
+		if calibrating != nil {
+			_, step := calibrating.Progress()
+			v.Value = int32(step)
+		} else {
+			v.Value = -1
+		}
+	case vendorTable:
+		e, _ := table.Entry(int(tableIndex))
+		v.Value = int32(e)
+	}
+	return v
+}
+
+// comboHold is how long both paddles must be held to recenter the wheel.
+const comboHold = 3 * time.Second
+
+var (
+	comboSince time.Time
+	comboFired bool
+)
+
+// checkCombo recenters the wheel once both shift paddles were held down
+// together for comboHold.
+func checkCombo(up, down bool) {
+	switch {
+	case !up || !down:
+		comboSince = time.Time{}
+		comboFired = false
+	case comboSince.IsZero():
+		comboSince = time.Now()
+	case !comboFired && time.Since(comboSince) > comboHold:
+		comboFired = true
+		post(calibrateCenter)
+	}
+}
+
+// rangeHold is how far both the brake and the clutch must be pressed for
+// the paddles to step the rotation range.
+const rangeHold = 30000
+
+var rangeUp, rangeDown bool
+
+// checkRangeCombo steps the rotation range by RangeStep on each paddle
+// press while the brake and the clutch are held down.
+func checkRangeCombo(brake, clutch int32, up, down bool) {
+	held := brake > rangeHold && clutch > rangeHold
+	switch {
+	case held && up && !rangeUp:
+		post(func() { setRange(lock2Lock() + RangeStep) })
+	case held && down && !rangeDown:
+		post(func() { setRange(lock2Lock() - RangeStep) })
+	}
+	rangeUp, rangeDown = up, down
+}
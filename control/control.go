@@ -0,0 +1,207 @@
+// Package control sequences the wheel from power up to putting out torque
+// and back down on faults.
+package control
+
+import "time"
+
+type State uint8
+
+const (
+	StateBoot    State = iota
+	StateSetup         // configuring the motors
+	StateHoming        // waiting for the wheel position
+	StateRampIn        // fading torque in
+	StateRunning       // full torque
+	StateFault         // torque off until the cause clears
+	StateStandby       // torque off on request
+)
+
+var stateNames = [...]string{"boot", "setup", "homing", "ramp-in", "running", "fault", "standby"}
+
+func (s State) String() string {
+	if int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return "unknown"
+}
+
+// Cause tells why the machine went to StateFault.
+type Cause uint8
+
+const (
+	CauseNone   Cause = iota
+	CauseSetup        // motor setup failed
+	CauseMotor        // no usable feedback from the motor
+	CauseDrive        // the motor reports a fault that stops it
+	CauseSafety       // a safety trip is latched
+)
+
+var causeNames = [...]string{"none", "setup", "motor", "drive", "safety"}
+
+func (c Cause) String() string {
+	if int(c) < len(causeNames) {
+		return causeNames[c]
+	}
+	return "unknown"
+}
+
+type Config struct {
+	RampIn      time.Duration // fade in after homing
+	HomeTimeout time.Duration // time allowed to get a position
+	FaultAfter  time.Duration // how long errors last before a fault
+	Retry       time.Duration // wait before setting the motors up again
+}
+
+var DefaultConfig = Config{
+	RampIn:      3 * time.Second,
+	HomeTimeout: time.Second,
+	FaultAfter:  100 * time.Millisecond,
+	Retry:       time.Second,
+}
+
+// Machine is the state machine. Its methods are called from the control
+// loop with the time of the tick.
+type Machine struct {
+	cfg     Config
+	state   State
+	cause   Cause
+	since   time.Time
+	errorAt time.Time // first of the current run of errors
+	changed func(from, to State)
+}
+
+func New(cfg Config) *Machine {
+	return &Machine{cfg: cfg}
+}
+
+// OnChange installs f to be called on every state change.
+func (m *Machine) OnChange(f func(from, to State)) {
+	m.changed = f
+}
+
+func (m *Machine) State() State {
+	return m.state
+}
+
+// Cause returns why the machine is in StateFault.
+func (m *Machine) Cause() Cause {
+	return m.cause
+}
+
+// Since returns when the current state was entered.
+func (m *Machine) Since() time.Time {
+	return m.since
+}
+
+func (m *Machine) set(now time.Time, s State) {
+	if s == m.state {
+		return
+	}
+	from := m.state
+	m.state, m.since = s, now
+	m.errorAt = time.Time{}
+	if s != StateFault {
+		m.cause = CauseNone
+	}
+	if m.changed != nil {
+		m.changed(from, s)
+	}
+}
+
+// Start begins the motor setup.
+func (m *Machine) Start(now time.Time) {
+	m.set(now, StateSetup)
+}
+
+// SetupDone ends the motor setup with its result.
+func (m *Machine) SetupDone(now time.Time, err error) {
+	if m.state != StateSetup {
+		return
+	}
+	if err != nil {
+		m.Fail(now, CauseSetup)
+		return
+	}
+	m.set(now, StateHoming)
+}
+
+// Fail goes to StateFault for cause. The cause is set first so that the
+// OnChange callback sees it.
+func (m *Machine) Fail(now time.Time, cause Cause) {
+	m.cause = cause
+	m.set(now, StateFault)
+}
+
+// Standby releases torque until Resume.
+func (m *Machine) Standby(now time.Time) {
+	switch m.state {
+	case StateHoming, StateRampIn, StateRunning:
+		m.set(now, StateStandby)
+	}
+}
+
+// Resume leaves StateStandby.
+func (m *Machine) Resume(now time.Time) {
+	if m.state == StateStandby {
+		m.set(now, StateHoming)
+	}
+}
+
+// Update advances the machine on a tick of the control loop. ok tells that
+// the motor feedback is fresh, cause what stops the torque otherwise.
+// It returns whether the motors have to be set up again.
+func (m *Machine) Update(now time.Time, ok bool, cause Cause) (setup bool) {
+	switch m.state {
+	case StateHoming:
+		switch {
+		case ok && cause == CauseNone:
+			m.set(now, StateRampIn)
+		case now.Sub(m.since) > m.cfg.HomeTimeout:
+			m.Fail(now, CauseMotor)
+		}
+	case StateRampIn, StateRunning:
+		switch {
+		case cause != CauseNone:
+			m.Fail(now, cause)
+		case !ok:
+			if m.errorAt.IsZero() {
+				m.errorAt = now
+			} else if now.Sub(m.errorAt) > m.cfg.FaultAfter {
+				m.Fail(now, CauseMotor)
+			}
+		default:
+			m.errorAt = time.Time{}
+			if m.state == StateRampIn && now.Sub(m.since) >= m.cfg.RampIn {
+				m.set(now, StateRunning)
+			}
+		}
+	case StateFault:
+		switch m.cause {
+		case CauseSetup, CauseMotor:
+			if now.Sub(m.since) > m.cfg.Retry {
+				m.set(now, StateSetup)
+				return true
+			}
+		default:
+			if ok && cause == CauseNone {
+				m.set(now, StateHoming)
+			}
+		}
+	}
+	return false
+}
+
+// Share returns the share of torque in 1/256 the state allows.
+func (m *Machine) Share(now time.Time) int32 {
+	switch m.state {
+	case StateRunning:
+		return 256
+	case StateRampIn:
+		d := now.Sub(m.since)
+		if d >= m.cfg.RampIn {
+			return 256
+		}
+		return int32(int64(256) * int64(d) / int64(m.cfg.RampIn))
+	}
+	return 0
+}
@@ -0,0 +1,129 @@
+package control
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var t0 = time.Unix(1000, 0)
+
+func at(d time.Duration) time.Time {
+	return t0.Add(d)
+}
+
+// step is one call on the machine and the state it must end in.
+type step struct {
+	name  string
+	do    func(m *Machine) bool // returns whether to set up again
+	state State
+	cause Cause
+	setup bool
+}
+
+func act(f func(m *Machine)) func(m *Machine) bool {
+	return func(m *Machine) bool { f(m); return false }
+}
+
+func update(d time.Duration, ok bool, cause Cause) func(m *Machine) bool {
+	return func(m *Machine) bool { return m.Update(at(d), ok, cause) }
+}
+
+func TestTransitions(t *testing.T) {
+	cfg := Config{
+		RampIn:      time.Second,
+		HomeTimeout: 100 * time.Millisecond,
+		FaultAfter:  10 * time.Millisecond,
+		Retry:       500 * time.Millisecond,
+	}
+	for _, tc := range []struct {
+		name  string
+		steps []step
+	}{
+		{"power up", []step{
+			{"start", act(func(m *Machine) { m.Start(at(0)) }), StateSetup, CauseNone, false},
+			{"setup done", act(func(m *Machine) { m.SetupDone(at(0), nil) }), StateHoming, CauseNone, false},
+			{"homed", update(time.Millisecond, true, CauseNone), StateRampIn, CauseNone, false},
+			{"ramping", update(500*time.Millisecond, true, CauseNone), StateRampIn, CauseNone, false},
+			{"ramped", update(1100*time.Millisecond, true, CauseNone), StateRunning, CauseNone, false},
+		}},
+		{"setup fails and retries", []step{
+			{"start", act(func(m *Machine) { m.Start(at(0)) }), StateSetup, CauseNone, false},
+			{"setup error", act(func(m *Machine) { m.SetupDone(at(0), errors.New("no reply")) }), StateFault, CauseSetup, false},
+			{"waiting", update(100*time.Millisecond, false, CauseNone), StateFault, CauseSetup, false},
+			{"retry", update(600*time.Millisecond, false, CauseNone), StateSetup, CauseNone, true},
+		}},
+		{"no position while homing", []step{
+			{"start", act(func(m *Machine) { m.Start(at(0)); m.SetupDone(at(0), nil) }), StateHoming, CauseNone, false},
+			{"stale", update(50*time.Millisecond, false, CauseNone), StateHoming, CauseNone, false},
+			{"timeout", update(150*time.Millisecond, false, CauseNone), StateFault, CauseMotor, false},
+		}},
+		{"drive fault while running", []step{
+			{"running", act(func(m *Machine) { m.state, m.since = StateRunning, at(0) }), StateRunning, CauseNone, false},
+			{"fault", update(time.Millisecond, true, CauseDrive), StateFault, CauseDrive, false},
+			{"still faulted", update(2*time.Millisecond, true, CauseDrive), StateFault, CauseDrive, false},
+			{"cleared", update(3*time.Millisecond, true, CauseNone), StateHoming, CauseNone, false},
+		}},
+		{"feedback lost while running", []step{
+			{"running", act(func(m *Machine) { m.state, m.since = StateRunning, at(0) }), StateRunning, CauseNone, false},
+			{"first miss", update(time.Millisecond, false, CauseNone), StateRunning, CauseNone, false},
+			{"short gap", update(5*time.Millisecond, false, CauseNone), StateRunning, CauseNone, false},
+			{"back", update(6*time.Millisecond, true, CauseNone), StateRunning, CauseNone, false},
+			{"miss again", update(20*time.Millisecond, false, CauseNone), StateRunning, CauseNone, false},
+			{"long gap", update(40*time.Millisecond, false, CauseNone), StateFault, CauseMotor, false},
+		}},
+		{"standby", []step{
+			{"running", act(func(m *Machine) { m.state, m.since = StateRunning, at(0) }), StateRunning, CauseNone, false},
+			{"standby", act(func(m *Machine) { m.Standby(at(0)) }), StateStandby, CauseNone, false},
+			{"ignores updates", update(time.Millisecond, false, CauseDrive), StateStandby, CauseNone, false},
+			{"resume", act(func(m *Machine) { m.Resume(at(0)) }), StateHoming, CauseNone, false},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := New(cfg)
+			for _, s := range tc.steps {
+				setup := s.do(m)
+				if m.State() != s.state || m.Cause() != s.cause || setup != s.setup {
+					t.Fatalf("%s: %s/%s setup %v, want %s/%s setup %v",
+						s.name, m.State(), m.Cause(), setup, s.state, s.cause, s.setup)
+				}
+			}
+		})
+	}
+}
+
+// TestOnChangeSeesCause checks the callback is run with the cause of the
+// fault already set, as it reports it.
+func TestOnChangeSeesCause(t *testing.T) {
+	m := New(DefaultConfig)
+	var got []Cause
+	m.OnChange(func(from, to State) {
+		got = append(got, m.Cause())
+	})
+	m.Start(at(0))
+	m.SetupDone(at(0), errors.New("no reply"))
+	if len(got) != 2 || got[0] != CauseNone || got[1] != CauseSetup {
+		t.Fatalf("causes seen %v, want [none setup]", got)
+	}
+}
+
+func TestShare(t *testing.T) {
+	m := New(Config{RampIn: time.Second})
+	m.state, m.since = StateRampIn, at(0)
+	for _, tc := range []struct {
+		d    time.Duration
+		want int32
+	}{
+		{0, 0},
+		{250 * time.Millisecond, 64},
+		{time.Second, 256},
+	} {
+		if got := m.Share(at(tc.d)); got != tc.want {
+			t.Errorf("share after %v = %d, want %d", tc.d, got, tc.want)
+		}
+	}
+	m.state = StateFault
+	if got := m.Share(at(0)); got != 0 {
+		t.Errorf("share in fault = %d, want 0", got)
+	}
+}
@@ -0,0 +1,73 @@
+// Package estimator recovers smooth velocity and acceleration from sampled
+// positions.
+package estimator
+
+import (
+	"math"
+	"time"
+)
+
+// Estimator is an alpha-beta-gamma tracking filter. Its gains place all
+// three poles at the bandwidth, which gives a critically damped response
+// to steps in position. Higher bandwidth follows the wheel closer, lower
+// bandwidth smooths the encoder steps more.
+type Estimator struct {
+	Bandwidth float32 // Hz
+
+	pos, vel, acc float32 // counts, counts/s, counts/s²
+	last          time.Time
+}
+
+func New(bandwidth float32) *Estimator {
+	return &Estimator{Bandwidth: bandwidth}
+}
+
+// Update takes a position in counts sampled at t. A sample taken at the
+// same time as the last one is ignored.
+func (e *Estimator) Update(t time.Time, pos int32) {
+	dt := float32(t.Sub(e.last).Seconds())
+	if dt == 0 {
+		return // the same sample again
+	}
+	e.last = t
+	if dt < 0 || dt > 0.1 {
+		// first sample or a gap the model can't bridge
+		e.pos, e.vel, e.acc = float32(pos), 0, 0
+		return
+	}
+	// fading memory gains for a triple pole at exp(-w dt); gamma is 2k of
+	// the g-h-k form, so it takes no factor of 2 below
+	th := float32(math.Exp(-2 * math.Pi * float64(e.Bandwidth*dt)))
+	d := 1 - th
+	alpha := 1 - th*th*th
+	beta := 1.5 * d * d * (1 + th)
+	gamma := d * d * d
+	// predict
+	e.pos += e.vel*dt + e.acc*dt*dt/2
+	e.vel += e.acc * dt
+	// correct
+	r := float32(pos) - e.pos
+	e.pos += alpha * r
+	e.vel += beta * r / dt
+	e.acc += gamma * r / (dt * dt)
+}
+
+// Position returns the filtered position in counts.
+func (e *Estimator) Position() float32 {
+	return e.pos
+}
+
+// Velocity returns the velocity in counts/s.
+func (e *Estimator) Velocity() float32 {
+	return e.vel
+}
+
+// Acceleration returns the acceleration in counts/s².
+func (e *Estimator) Acceleration() float32 {
+	return e.acc
+}
+
+func (e *Estimator) Reset() {
+	e.pos, e.vel, e.acc = 0, 0, 0
+	e.last = time.Time{}
+}
@@ -0,0 +1,106 @@
+package estimator
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+const rate = 1000 // Hz
+
+var t0 = time.Unix(1000, 0)
+
+// run feeds the estimator rate samples of f over seconds and returns it
+// along with the position error of every sample.
+func run(bandwidth float32, seconds float64, f func(t float64) float64) (*Estimator, []float64) {
+	e := New(bandwidth)
+	var errs []float64
+	for i := 0; i <= int(seconds*rate); i++ {
+		t := float64(i) / rate
+		e.Update(t0.Add(time.Duration(i)*time.Second/rate), int32(math.Round(f(t))))
+		errs = append(errs, float64(e.Position())-f(t))
+	}
+	return e, errs
+}
+
+func near(got, want, tol float64) bool {
+	return math.Abs(got-want) <= tol
+}
+
+func TestTracksRamp(t *testing.T) {
+	const v = 20000 // counts/s
+	e, errs := run(20, 1, func(t float64) float64 { return v * t })
+	if !near(errs[len(errs)-1], 0, 1) {
+		t.Errorf("position error %.2f, want 0", errs[len(errs)-1])
+	}
+	if !near(float64(e.Velocity()), v, 0.01*v) {
+		t.Errorf("velocity %.0f, want %d", e.Velocity(), v)
+	}
+	if !near(float64(e.Acceleration()), 0, 0.01*v) {
+		t.Errorf("acceleration %.0f, want 0", e.Acceleration())
+	}
+}
+
+func TestTracksParabola(t *testing.T) {
+	const a = 40000 // counts/s²
+	e, errs := run(20, 1, func(t float64) float64 { return a * t * t / 2 })
+	if !near(errs[len(errs)-1], 0, 1) {
+		t.Errorf("position error %.2f, want 0", errs[len(errs)-1])
+	}
+	if !near(float64(e.Velocity()), a, 0.01*a) {
+		t.Errorf("velocity %.0f, want %d", e.Velocity(), a)
+	}
+	if !near(float64(e.Acceleration()), a, 0.02*a) {
+		t.Errorf("acceleration %.0f, want %d", e.Acceleration(), a)
+	}
+}
+
+// TestStepSettles checks the triple pole is critically damped on a step.
+// A tracker that follows a parabola without error cannot take a step
+// without overshoot, as the error integral has to come back to zero, but
+// it must do so in a single swing instead of ringing.
+func TestStepSettles(t *testing.T) {
+	const step = 1000
+	e, errs := run(20, 1, func(t float64) float64 {
+		if t < 0.5 {
+			return 0
+		}
+		return step
+	})
+	peak, swings, sign := 0.0, 0, 0.0
+	for _, err := range errs[rate/2:] {
+		if err > peak {
+			peak = err
+		}
+		if math.Abs(err) < 0.5 {
+			continue // settled within a count
+		}
+		if err*sign < 0 {
+			swings++
+		}
+		sign = err
+	}
+	if peak > 0.18*step {
+		t.Errorf("overshoot of %.0f counts, want at most %d", peak, step*18/100)
+	}
+	if swings > 2 {
+		t.Errorf("error changed sign %d times, want it to swing over once and back", swings)
+	}
+	if !near(errs[len(errs)-1], 0, 1) {
+		t.Errorf("position error %.2f after settling, want 0", errs[len(errs)-1])
+	}
+	if !near(float64(e.Velocity()), 0, 1) {
+		t.Errorf("velocity %.1f after settling, want 0", e.Velocity())
+	}
+}
+
+func TestGapRestarts(t *testing.T) {
+	e := New(20)
+	e.Update(t0, 100)
+	e.Update(t0.Add(time.Millisecond), 200)
+	e.Update(t0.Add(time.Second), 5000)
+	if e.Position() != 5000 || e.Velocity() != 0 || e.Acceleration() != 0 {
+		t.Errorf("after a gap got %.0f, %.0f, %.0f, want 5000, 0, 0",
+			e.Position(), e.Velocity(), e.Acceleration())
+	}
+}
@@ -0,0 +1,86 @@
+// Package ffb holds the forces the wheel generates by itself, on top of the
+// effects played by the host.
+package ffb
+
+// CountsPerTurn is the angle resolution the forces work in, the same as
+// motor.MotorState.Angle.
+const CountsPerTurn = 32768
+
+// EndStop is a soft stop at each end of the rotation range. The spring
+// fades in quadratically over Width counts before the limit, so there is
+// no step in force at the onset, and continues linearly past it. Forces
+// are in torque output units, positive towards positive angles.
+type EndStop struct {
+	Stiffness int32 // output per count past the limit
+	Damping   int32 // output per rpm inside the stop
+	Width     int32 // counts before the limit where the spring fades in
+	Wall      int32 // extra output once past the limit, 0 for a soft stop
+
+	limit int32
+}
+
+// DefaultEndStop is tuned to the motor the wheel was built with.
+var DefaultEndStop = EndStop{
+	Stiffness: 10,
+	Damping:   32,
+	Width:     CountsPerTurn / 72, // 5 deg
+}
+
+// SetRange puts the limits at ±lock/2 for a lock to lock rotation in
+// degrees.
+func (e *EndStop) SetRange(lock int32) {
+	e.limit = CountsPerTurn * lock / 2 / 360
+}
+
+// Limit returns the angle of the positive limit.
+func (e *EndStop) Limit() int32 {
+	return e.limit
+}
+
+// Side returns 1 or -1 when angle is past the positive or negative limit,
+// 0 within the range.
+func (e *EndStop) Side(angle int32) int {
+	switch {
+	case angle > e.limit:
+		return 1
+	case angle < -e.limit:
+		return -1
+	}
+	return 0
+}
+
+// Force returns the force of the stops at angle, moving at vel rpm.
+func (e *EndStop) Force(angle, vel int32) int32 {
+	sign := int32(1)
+	if angle < 0 {
+		sign, angle, vel = -1, -angle, -vel
+	}
+	d := angle - (e.limit - e.Width)
+	if d <= 0 {
+		return 0
+	}
+	var f int64
+	if e.Width > 0 && d < e.Width {
+		f = int64(e.Stiffness) * int64(d) * int64(d) / int64(2*e.Width)
+	} else {
+		f = int64(e.Stiffness) * int64(2*d-e.Width) / 2
+	}
+	if d > e.Width {
+		f += int64(e.Wall)
+	}
+	// Damping fades in with the spring and opposes motion both ways, which
+	// stops the wheel bouncing back off the stop.
+	damp := int64(e.Damping) * int64(vel)
+	if e.Width > 0 && d < e.Width {
+		damp = damp * int64(d) / int64(e.Width)
+	}
+	f += damp
+	if f < 0 {
+		// don't pull the wheel into the stop when it leaves fast
+		f = 0
+	}
+	if f > 1<<30 {
+		f = 1 << 30
+	}
+	return -sign * int32(f)
+}
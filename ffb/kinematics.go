@@ -0,0 +1,62 @@
+package ffb
+
+import (
+	"time"
+
+	"diy-ffb-wheel/estimator"
+)
+
+// Kinematics follows the wheel motion in angle counts and seconds.
+type Kinematics struct {
+	Position     int32 // counts
+	Velocity     int32 // counts/s
+	Acceleration int32 // counts/s²
+	Change       int32 // counts moved since the last update
+
+	est   estimator.Estimator
+	valid bool
+}
+
+// NewKinematics returns Kinematics estimating the motion with bandwidth in
+// Hz.
+func NewKinematics(bandwidth float32) *Kinematics {
+	return &Kinematics{est: estimator.Estimator{Bandwidth: bandwidth}}
+}
+
+// Update takes the wheel angle sampled at t.
+func (k *Kinematics) Update(t time.Time, angle int32) {
+	k.est.Update(t, angle)
+	if k.valid {
+		k.Change = angle - k.Position
+	}
+	k.Position, k.valid = angle, true
+	k.Velocity = int32(k.est.Velocity())
+	k.Acceleration = int32(k.est.Acceleration())
+}
+
+// Reset forgets the motion, as after the zero of the angle moved, so the
+// next Update starts over from its angle instead of taking the jump for a
+// move of the wheel.
+func (k *Kinematics) Reset() {
+	k.est.Reset()
+	k.Position, k.Velocity, k.Acceleration, k.Change = 0, 0, 0, 0
+	k.valid = false
+}
+
+// RPM returns the velocity in rpm.
+func (k *Kinematics) RPM() int32 {
+	return int32(int64(k.Velocity) * 60 / CountsPerTurn)
+}
+
+// Limits are the full scale of each metric for a rotation range ending at
+// ±limit counts and a loop running at rate Hz: the wheel sweeps half the
+// range in half a second at full velocity and reaches that velocity in a
+// tenth of a second.
+func Limits(limit int32, rate int) (position, velocity, acceleration, change int32) {
+	velocity = 2 * limit
+	change = velocity / int32(rate)
+	if change < 1 {
+		change = 1
+	}
+	return limit, velocity, 10 * velocity, change
+}
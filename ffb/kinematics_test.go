@@ -0,0 +1,23 @@
+package ffb
+
+import (
+	"testing"
+	"time"
+)
+
+// TestKinematicsReset checks a recenter is not taken for a move of the
+// wheel.
+func TestKinematicsReset(t *testing.T) {
+	k := NewKinematics(20)
+	t0 := time.Unix(1000, 0)
+	for i := 0; i < 100; i++ {
+		k.Update(t0.Add(time.Duration(i)*time.Millisecond), 5000)
+	}
+	k.Reset()
+	k.Update(t0.Add(100*time.Millisecond), 0)
+	k.Update(t0.Add(101*time.Millisecond), 0)
+	if k.Position != 0 || k.Velocity != 0 || k.Acceleration != 0 || k.Change != 0 {
+		t.Errorf("after a recenter got position %d, velocity %d, acceleration %d, change %d, want all 0",
+			k.Position, k.Velocity, k.Acceleration, k.Change)
+	}
+}
@@ -0,0 +1,51 @@
+package ffb
+
+// Full scale of the natural effects, at a strength of 100%.
+const (
+	DamperGain    = 128  // output per rpm
+	FrictionForce = 2000 // output
+	FrictionKnee  = 4    // rpm where friction reaches full force
+	InertiaGain   = 2    // output per rpm/s
+	SpringGain    = 1    // output per axis count
+	SpringMax     = 500  // output
+)
+
+// Natural are the effects the wheel plays under any game. The spring only
+// centers the wheel while the game plays nothing. Strengths are in percent
+// of the full scale and 0 turns an effect off.
+type Natural struct {
+	Damper   int32
+	Friction int32
+	Inertia  int32
+	Spring   int32
+}
+
+// Force returns the natural effects for a wheel moving as k, at angle on
+// the axis scale. idle tells that no game effect is playing.
+func (n *Natural) Force(k *Kinematics, angle int32, idle bool) int32 {
+	// gains are per rpm, motion is in counts
+	const perRPM = 60
+	f := int32(-int64(DamperGain) * int64(k.Velocity) * perRPM / CountsPerTurn * int64(n.Damper) / 100)
+	if n.Friction != 0 {
+		fr := int32(FrictionForce)
+		switch vel := k.RPM(); {
+		case vel < FrictionKnee && vel > -FrictionKnee:
+			fr = int32(int64(fr) * int64(k.Velocity) * perRPM / CountsPerTurn / FrictionKnee)
+		case vel < 0:
+			fr = -fr
+		}
+		f -= fr * n.Friction / 100
+	}
+	f -= int32(int64(InertiaGain) * int64(k.Acceleration) * perRPM / CountsPerTurn * int64(n.Inertia) / 100)
+	if idle && n.Spring != 0 {
+		s := -SpringGain * angle
+		switch {
+		case s > SpringMax:
+			s = SpringMax
+		case s < -SpringMax:
+			s = -SpringMax
+		}
+		f += s * n.Spring / 100
+	}
+	return f
+}
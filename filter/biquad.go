@@ -0,0 +1,60 @@
+package filter
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Biquad is a second order IIR section in transposed direct form II.
+// Coefficients follow the RBJ audio EQ cookbook.
+type Biquad struct {
+	b0, b1, b2, a1, a2 float32
+	z1, z2             float32
+}
+
+// LowPass returns a low-pass at fc Hz for a sample rate of fs Hz. A q of
+// 0.7071 gives a Butterworth response.
+func LowPass(fs, fc, q float64) *Biquad {
+	w := 2 * math.Pi * fc / fs
+	cos, alpha := math.Cos(w), math.Sin(w)/(2*q)
+	return newBiquad((1-cos)/2, 1-cos, (1-cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// Notch returns a band-stop at f0 Hz for a sample rate of fs Hz. Higher q
+// makes the notch narrower.
+func Notch(fs, f0, q float64) *Biquad {
+	w := 2 * math.Pi * f0 / fs
+	cos, alpha := math.Cos(w), math.Sin(w)/(2*q)
+	return newBiquad(1, -2*cos, 1, 1+alpha, -2*cos, 1-alpha)
+}
+
+func newBiquad(b0, b1, b2, a0, a1, a2 float64) *Biquad {
+	return &Biquad{
+		b0: float32(b0 / a0),
+		b1: float32(b1 / a0),
+		b2: float32(b2 / a0),
+		a1: float32(a1 / a0),
+		a2: float32(a2 / a0),
+	}
+}
+
+func (b *Biquad) Filter(x int32) int32 {
+	in := float32(x)
+	out := b.b0*in + b.z1
+	b.z1 = b.b1*in - b.a1*out + b.z2
+	b.z2 = b.b2*in - b.a2*out
+	return int32(out)
+}
+
+func (b *Biquad) Reset() {
+	b.z1, b.z2 = 0, 0
+}
+
+// Response returns the gain of the filter at f Hz for a sample rate of fs
+// Hz.
+func (b *Biquad) Response(fs, f float64) float64 {
+	z := cmplx.Exp(complex(0, -2*math.Pi*f/fs)) // z^-1
+	num := complex(float64(b.b0), 0) + complex(float64(b.b1), 0)*z + complex(float64(b.b2), 0)*z*z
+	den := 1 + complex(float64(b.a1), 0)*z + complex(float64(b.a2), 0)*z*z
+	return cmplx.Abs(num / den)
+}
@@ -0,0 +1,72 @@
+package filter
+
+import (
+	"math"
+	"testing"
+)
+
+const fs = 1000
+
+func TestLowPassResponse(t *testing.T) {
+	lp := LowPass(fs, 120, math.Sqrt2/2)
+	for _, tc := range []struct {
+		f, gain, tol float64
+	}{
+		{0, 1, 1e-3},
+		{10, 1, 1e-3},
+		{120, math.Sqrt2 / 2, 1e-3}, // -3 dB at the corner
+		{480, 0, 0.05},
+	} {
+		if g := lp.Response(fs, tc.f); math.Abs(g-tc.gain) > tc.tol {
+			t.Errorf("gain at %v Hz = %.4f, want %.4f", tc.f, g, tc.gain)
+		}
+	}
+}
+
+func TestNotchResponse(t *testing.T) {
+	n := Notch(fs, 50, 1)
+	if g := n.Response(fs, 50); g > 1e-3 {
+		t.Errorf("gain at the notch = %.4f, want 0", g)
+	}
+	// The -3 dB points of a notch lie f0/q apart, give or take the warping
+	// of the bilinear transform.
+	lo, hi := 50*(math.Sqrt(5)-1)/2, 50*(math.Sqrt(5)+1)/2
+	for _, f := range []float64{lo, hi} {
+		if g := n.Response(fs, f); math.Abs(g-math.Sqrt2/2) > 0.02 {
+			t.Errorf("gain at %.1f Hz = %.4f, want -3 dB", f, g)
+		}
+	}
+	for _, f := range []float64{1, 400} {
+		if g := n.Response(fs, f); math.Abs(g-1) > 0.01 {
+			t.Errorf("gain at %v Hz = %.4f, want 1", f, g)
+		}
+	}
+}
+
+// TestBiquadFilterMatchesResponse checks the sample by sample filter
+// against the response it reports, by the amplitude of a settled sine.
+func TestBiquadFilterMatchesResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		b    *Biquad
+		f    float64
+	}{
+		{"lowpass corner", LowPass(fs, 120, math.Sqrt2/2), 120},
+		{"lowpass stop", LowPass(fs, 120, math.Sqrt2/2), 300},
+		{"notch", Notch(fs, 50, 1), 50},
+		{"notch pass", Notch(fs, 50, 1), 200},
+	} {
+		const amp = 10000
+		peak := int32(0)
+		for i := 0; i < 2*fs; i++ {
+			y := tc.b.Filter(int32(amp * math.Sin(2*math.Pi*tc.f*float64(i)/fs)))
+			if i >= fs && y > peak {
+				peak = y
+			}
+		}
+		want := amp * tc.b.Response(fs, tc.f)
+		if math.Abs(float64(peak)-want) > 0.02*amp {
+			t.Errorf("%s: peak %d, want %.0f", tc.name, peak, want)
+		}
+	}
+}
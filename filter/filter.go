@@ -0,0 +1,63 @@
+// Package filter shapes the torque output sample by sample.
+package filter
+
+import "math"
+
+// Filter takes one sample per control loop tick.
+type Filter interface {
+	Filter(x int32) int32
+	Reset()
+}
+
+// Chain runs filters in order.
+type Chain []Filter
+
+func (c Chain) Filter(x int32) int32 {
+	for _, f := range c {
+		x = f.Filter(x)
+	}
+	return x
+}
+
+func (c Chain) Reset() {
+	for _, f := range c {
+		f.Reset()
+	}
+}
+
+// Config describes the chains on the torque output path. Zero values leave
+// a stage out.
+type Config struct {
+	HostRate int     // Hz the host updates effects at, for interpolation
+	Slew     int32   // output change per second
+	LowPass  float64 // Hz
+	Notch    float64 // Hz of a mechanical resonance
+	NotchQ   float64 // 1 when left zero
+}
+
+// Build returns the chains for a loop running at rate Hz: stream
+// interpolates the forces the host streams, which only change with its
+// reports, host limits how fast the force of all host effects changes, and
+// out keeps the total output clear of the resonances. Effects computed on
+// every tick, such as springs and dampers, must not pass stream, whose lag
+// would act on the wheel motion they follow.
+func (c Config) Build(rate int) (stream, host, out Chain) {
+	if c.HostRate > 0 && rate > c.HostRate {
+		stream = append(stream, NewInterp(int32(rate/c.HostRate)))
+	}
+	if c.Slew > 0 {
+		host = append(host, NewSlew(c.Slew/int32(rate)+1))
+	}
+	fs := float64(rate)
+	if c.LowPass > 0 && c.LowPass < fs/2 {
+		out = append(out, LowPass(fs, c.LowPass, math.Sqrt2/2))
+	}
+	if c.Notch > 0 && c.Notch < fs/2 {
+		q := c.NotchQ
+		if q <= 0 {
+			q = 1
+		}
+		out = append(out, Notch(fs, c.Notch, q))
+	}
+	return stream, host, out
+}
@@ -0,0 +1,39 @@
+package filter
+
+import "testing"
+
+func TestInterpRamps(t *testing.T) {
+	p := NewInterp(4)
+	var got []int32
+	for _, x := range []int32{400, 400, 400, 400, 400, 0, 0} {
+		got = append(got, p.Filter(x))
+	}
+	want := []int32{100, 200, 300, 400, 400, 300, 200}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSlewLimits(t *testing.T) {
+	s := NewSlew(10)
+	for i, want := range []int32{10, 20, 25} {
+		if y := s.Filter(25); y != want {
+			t.Errorf("sample %d = %d, want %d", i, y, want)
+		}
+	}
+}
+
+func TestBuildStreamOnly(t *testing.T) {
+	stream, host, out := Config{HostRate: 100, Slew: 1000, LowPass: 120}.Build(500)
+	if len(stream) != 1 || len(host) != 1 || len(out) != 1 {
+		t.Fatalf("chains %d, %d, %d long, want 1 each", len(stream), len(host), len(out))
+	}
+	if _, ok := stream[0].(*Interp); !ok {
+		t.Errorf("stream chain holds %T, want *Interp", stream[0])
+	}
+	if _, ok := host[0].(*Slew); !ok {
+		t.Errorf("host chain holds %T, want *Slew", host[0])
+	}
+}
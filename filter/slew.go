@@ -0,0 +1,64 @@
+package filter
+
+// Slew limits how far the output moves per sample.
+type Slew struct {
+	Max int32 // change per sample
+	y   int32
+}
+
+func NewSlew(max int32) *Slew {
+	return &Slew{Max: max}
+}
+
+func (s *Slew) Filter(x int32) int32 {
+	switch d := x - s.y; {
+	case d > s.Max:
+		s.y += s.Max
+	case d < -s.Max:
+		s.y -= s.Max
+	default:
+		s.y = x
+	}
+	return s.y
+}
+
+func (s *Slew) Reset() {
+	s.y = 0
+}
+
+// Interp reconstructs a signal updated less often than it is sampled, as
+// host effects are, by ramping to each new value over Steps samples
+// instead of stepping to it.
+type Interp struct {
+	Steps int32
+	in    int32 // last input
+	from  int32 // output when the input last changed
+	n     int32 // samples since the input last changed
+}
+
+func NewInterp(steps int32) *Interp {
+	return &Interp{Steps: steps, n: steps}
+}
+
+func (p *Interp) Filter(x int32) int32 {
+	if x != p.in {
+		p.from = p.out()
+		p.in = x
+		p.n = 0
+	}
+	if p.n < p.Steps {
+		p.n++
+	}
+	return p.out()
+}
+
+func (p *Interp) out() int32 {
+	if p.Steps <= 0 || p.n >= p.Steps {
+		return p.in
+	}
+	return p.from + int32(int64(p.in-p.from)*int64(p.n)/int64(p.Steps))
+}
+
+func (p *Interp) Reset() {
+	p.in, p.from, p.n = 0, 0, p.Steps
+}
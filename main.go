@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"log"
 	"machine"
 	"machine/usb/joystick"
@@ -12,30 +13,91 @@ import (
 
 	"tinygo.org/x/drivers/mcp2515"
 
+	"diy-ffb-wheel/control"
+	"diy-ffb-wheel/ffb"
+	"diy-ffb-wheel/filter"
 	"diy-ffb-wheel/motor"
 	"diy-ffb-wheel/pid"
+	"diy-ffb-wheel/safety"
+	"diy-ffb-wheel/sched"
+	"diy-ffb-wheel/settings"
 	"diy-ffb-wheel/utils"
 )
 
 const (
-	DEBUG         = false
-	Lock2Lock     = 540
-	HalfLock2Lock = Lock2Lock / 2
-	MaxAngle      = 32768*HalfLock2Lock/360 - 1
+	DEBUG        = false
+	Lock2Lock    = 540  // default rotation range in degrees
+	MinLock2Lock = 90   // lowest range settable at runtime
+	MaxLock2Lock = 1440 // highest range settable at runtime
+	DerateTemp   = 70   // deg C where torque starts to be derated, not DDT
+	CutoffTemp   = 90   // deg C where torque reaches zero, not DDT
+	StaleAfter   = 20 * time.Millisecond
+	WatchdogTime = 500 // ms, longer than a settings write
+	LoopRate     = 100 // Hz, up to sched.MaxRate
+	Bandwidth    = 20  // Hz of the motion estimate, lower is smoother
 )
 
+var motorConfig = motor.Config{
+	Type:       "ddt",
+	ID:         1,
+	Mode:       motor.ModeCurrent,
+	Feedback:   motor.DDTQueryOnly,
+	MaxTorque:  2.0,
+	MaxCurrent: 20.0,
+	MaxSpeed:   10.0,
+	PolePairs:  7,
+}
+
+// filterConfig shapes the torque output. Set Notch to a resonance of the
+// wheel mechanics to keep it from ringing.
+var filterConfig = filter.Config{
+	HostRate: 100,
+	Slew:     32767 * 50, // full scale in 20 ms
+	LowPass:  120,
+}
+
 var (
 	spi   = machine.SPI0
 	csPin = machine.GP28
+	// intPin is the MCP2515 INT output, needed when the motor reports on
+	// its own (motorConfig.Feedback other than motor.DDTQueryOnly).
+	intPin = machine.GP27
+	// estopPin is a normally open e-stop switch to ground.
+	estopPin = machine.GP26
 )
 
+var errStale = errors.New("motor feedback is stale")
+
 var (
-	js *joystick.Joystick
-	ph *pid.PIDHandler
+	js    *joystick.Joystick
+	ph    *pid.PIDHandler
+	drv   motor.Driver
+	store settings.Storage = settings.Flash{}
+	conf                   = settings.Default()
+	loop  *sched.Loop
+	guard = safety.New(safety.DefaultConfig)
+	ctl   = control.New(control.DefaultConfig)
+
+	endStop      = ffb.DefaultEndStop
+	natural      ffb.Natural
+	kin          = ffb.NewKinematics(Bandwidth)
+	streamFilter filter.Chain      // forces the host streams
+	hostFilter   filter.Chain      // host effects
+	outFilter    filter.Chain      // total output
+	fit          func(int32) int32 // wheel angle to axis value
+)
+
+// stages of a control loop tick, in order.
+const (
+	stageState = iota
+	stageForces
+	stageTorque
+	stageHID
 )
 
 func init() {
 	ph = pid.NewPIDHandler()
+	ph.SetVendorHandler(vendorSet, vendorGet)
 	js = joystick.Enable(joystick.Definitions{
 		ReportID:     1,
 		ButtonCnt:    24,
@@ -87,6 +149,111 @@ func setShift(x, y int32) int {
 	return next
 }
 
+// setCenter stores zero as the encoder count of the wheel center.
+func setCenter(zero int32) error {
+	drv.Position().SetZero(zero)
+	kin.Reset()
+	conf.Centered = true
+	conf.CenterOffset = zero
+	return settings.Save(store, conf)
+}
+
+// saveConf stores the settings.
+func saveConf() error {
+	return settings.Save(store, conf)
+}
+
+// saveWithTorqueOff releases the torque, then runs save and logs its error.
+// Writing flash stalls the loop, which would leave the motor holding its
+// last command until the write is done.
+func saveWithTorqueOff(save func() error) {
+	if err := sendTorque(0); err != nil {
+		log.Print(err)
+	}
+	if err := save(); err != nil {
+		log.Print(err)
+	}
+}
+
+// torqueShare returns the share of torque in 1/256 the motor may deliver
+// under its reported faults and temperature. The DDT does not report its
+// temperature, so it is only derated by its over-temperature fault; the
+// derating between DerateTemp and CutoffTemp applies to ODrive and VESC.
+func torqueShare(state *motor.MotorState) int32 {
+	share := int32(256)
+	switch {
+	case state.Fault&motor.FaultsStop != 0:
+		return 0
+	case state.Fault&motor.FaultOverTemperature != 0:
+		share = 128
+	}
+	if t := int32(state.Temperature); t > DerateTemp {
+		share = share * (CutoffTemp - t) / (CutoffTemp - DerateTemp)
+	}
+	if share < 0 {
+		return 0
+	}
+	return share
+}
+
+// triggerButtons returns the state of the first 8 buttons, which may
+// trigger effects.
+func triggerButtons() uint8 {
+	var mask uint8
+	for i := 0; i < 8; i++ {
+		if js.GetButton(i) {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
+
+// effectParams describes the wheel motion to the condition effects, scaled
+// to the rotation range. Friction follows the filtered velocity rather than
+// the raw change per tick, which is a count or two at low speed and flips
+// sign with the encoder jitter.
+func effectParams() pid.EffectParams {
+	pos, vel, acc, _ := ffb.Limits(endStop.Limit(), loop.Rate())
+	return pid.EffectParams{
+		SpringMaxPosition:         pos,
+		SpringPosition:            kin.Position,
+		DamperMaxVelocity:         vel,
+		DamperVelocity:            kin.Velocity,
+		InertiaMaxAcceleration:    acc,
+		InertiaAcceleration:       kin.Acceleration,
+		FrictionMaxPositionChange: vel,
+		FrictionPositionChange:    kin.Velocity,
+	}
+}
+
+// sendStatus reports the loop state and the safety trips to the host.
+func sendStatus() {
+	b, _ := pid.VendorStatusInputData{
+		ReportID: pid.ReportVendorStatus,
+		State:    uint8(ctl.State()),
+		Cause:    uint8(ctl.Cause()),
+		Faults:   uint8(guard.Faults()),
+	}.MarshalBinary()
+	js.SendReport(b[0], b[1:])
+}
+
+// sendPIDStatus sends the PID state report when the device or an effect
+// changed. The safety switch is closed while the e-stop has not tripped and
+// the actuators are powered while the loop drives the motor.
+func sendPIDStatus() {
+	flags := uint8(0)
+	if guard.Faults()&safety.FaultEStop == 0 {
+		flags |= pid.StatusSafetySwitch
+	}
+	if st := ctl.State(); st == control.StateRampIn || st == control.StateRunning {
+		flags |= pid.StatusActuatorPower
+	}
+	if st, ok := ph.StatusChange(flags); ok {
+		b, _ := st.MarshalBinary()
+		js.SendReport(b[0], b[1:])
+	}
+}
+
 func absInt32(n int32) int32 {
 	if n < 0 {
 		return -n
@@ -114,6 +281,10 @@ func main() {
 		axises := make([]int32, 8)
 		scanner := bufio.NewScanner(os.Stdin)
 		for scanner.Scan() {
+			if isCommand(scanner.Text()) {
+				handleCommand(scanner.Text())
+				continue
+			}
 			for i, s := range strings.Split(scanner.Text(), ",") {
 				if i >= len(axises) {
 					break
@@ -128,6 +299,8 @@ func main() {
 				js.SetAxis(axMap[i], int(v))
 			}
 			shift := setShift(axises[0], axises[1])
+			checkCombo(axises[7] > 0, axises[6] > 0)
+			checkRangeCombo(axises[4], axises[5], axises[7] > 0, axises[6] > 0)
 			// for sequential mode
 			switch {
 			case axises[7] > 0:
@@ -157,49 +330,153 @@ func main() {
 	if err := can.Begin(mcp2515.CAN500kBps, mcp2515.Clock8MHz); err != nil {
 		log.Fatal(err)
 	}
-	if err := motor.Setup(can); err != nil {
+	var err error
+	bus := motor.NewMCP2515(can, spi, csPin)
+	rx := motor.NewQueue(bus, 16)
+	if motorConfig.Type != "ddt" || motorConfig.Feedback != motor.DDTQueryOnly {
+		bus.PollIntPin(intPin)
+		go rx.Run(200 * time.Microsecond)
+	}
+	if err = openMotors(rx); err != nil {
+		log.Fatal(err)
+	}
+	if conf, err = settings.Load(store); err != nil {
+		log.Print(err)
+	}
+	if conf.Centered {
+		drv.Position().SetZero(conf.CenterOffset)
+	}
+	applyProfile()
+	loadTable()
+	loop, err = sched.New(LoopRate, "state", "forces", "torque", "hid")
+	if err != nil {
 		log.Fatal(err)
 	}
-	ticker := time.NewTicker(10 * time.Millisecond)
-	fit := utils.Map(-MaxAngle, MaxAngle, -32767, 32767)
+	streamFilter, hostFilter, outFilter = filterConfig.Build(loop.Rate())
+	estopPin.Configure(machine.PinConfig{Mode: machine.PinInputPullup})
+	machine.Watchdog.Configure(machine.WatchdogConfig{TimeoutMillis: WatchdogTime})
+	if err := machine.Watchdog.Start(); err != nil {
+		log.Print(err)
+	}
+	// The motors are set up beside the loop, which keeps feeding the
+	// watchdog and leaves the bus alone meanwhile.
+	setupDone := make(chan error, 1)
+	setup := func() {
+		go func() { setupDone <- setupMotors() }()
+	}
+	ctl.OnChange(func(from, to control.State) {
+		log.Printf("state: %s -> %s", from, to)
+		sendStatus()
+	})
+	ctl.Start(time.Now())
+	setup()
 	limit1 := utils.Limit(-32767, 32767)
-	limit2 := utils.Limit(-500, 500)
 	cnt := 0
-	for range ticker.C {
-		state, err := motor.GetState(can)
+	lastFault := motor.Fault(0)
+	lastTrip := safety.Fault(0)
+	torque := int32(0)
+	for {
+		loop.Wait()
+		machine.Watchdog.Update()
+		runActions()
+		if ctl.State() == control.StateSetup {
+			select {
+			case err := <-setupDone:
+				if err != nil {
+					log.Printf("motor setup failed, torque disabled: %v", err)
+					if err := disableMotors(); err != nil {
+						log.Print(err)
+					}
+				}
+				ctl.SetupDone(time.Now(), err)
+			default:
+			}
+			continue
+		}
+		state, err := drv.State()
+		if err == nil && state.Age() > StaleAfter {
+			err = errStale
+		}
+		followerFault, followerShare := motor.Fault(0), int32(256)
+		if err == nil {
+			followerFault, followerShare, err = checkFollowers()
+		}
+		loop.Mark(stageState)
+		now := time.Now()
+		cause := control.CauseNone
+		switch {
+		case guard.Faults()&safety.FaultsStop != 0:
+			cause = control.CauseSafety
+		case err == nil && (state.Fault|followerFault)&motor.FaultsStop != 0:
+			cause = control.CauseDrive
+		}
+		if ctl.Update(now, err == nil, cause) {
+			setup()
+			continue
+		}
 		if err != nil {
-			log.Print(err)
+			if ctl.State() != control.StateFault {
+				log.Print(err)
+			}
+			torque = 0
+			if err := sendTorque(0); err != nil {
+				log.Print(err)
+			}
+			sendPIDStatus()
+			continue
+		}
+		if fault := state.Fault | followerFault; fault != lastFault {
+			log.Printf("motor fault: %s", fault)
+			lastFault = fault
 		}
 		angle := fit(state.Angle)
-		output := limit2(-angle) + int32(state.Verocity)*128
-		force := ph.CalcForces()
-		switch {
-		case angle > 32767:
-			output -= 8 * (angle - 32767)
-		case angle < -32767:
-			output -= 8 * (angle + 32767)
+		kin.Update(state.Time, state.Angle)
+		ph.SetEffectParams(effectParams())
+		output := natural.Force(kin, angle, !ph.Playing())
+		ph.Buttons(triggerButtons())
+		streamed, local := ph.CalcForces()
+		host, share := guard.Check(safety.Input{
+			Now:       now,
+			Received:  ph.Received(),
+			HostForce: streamed[0],
+			EStop:     !estopPin.Get(),
+			Speed:     int32(state.Verocity),
+			Torque:    torque,
+		})
+		if trip := guard.Faults(); trip != lastTrip {
+			log.Printf("safety: %s", trip)
+			lastTrip = trip
+			sendStatus()
 		}
-		output -= force[0]
-		if DEBUG && cnt%100 == 0 {
+		output += endStop.Force(state.Angle, kin.RPM())
+		output -= hostFilter.Filter((streamFilter.Filter(streamed[0]) + local[0]) * host / 256)
+		output = outFilter.Filter(output)
+		loop.Mark(stageForces)
+		if DEBUG && cnt%loop.Rate() == 0 {
 			print(time.Now().UnixMilli(), ": ")
 			print("v:", state.Verocity, ", ")
 			print("c:", state.Current, ", ")
 			print("a:", angle, ", ")
-			print("f:", force[0], ", ", force[1], ", ")
+			print("f:", streamed[0], ", ", local[0], ", ")
 			print("o:", output, ", ", receiver)
 			println()
 		}
 		cnt++
-		if cnt < 300 {
-			output = output * int32(cnt) / 300
-		}
-		if err := motor.Output(can, int16(limit1(output))); err != nil {
+		output = compensate(now, output, state.Angle, drv.Position().Raw())
+		output = output * ctl.Share(now) / 256
+		output = output * torqueShare(state) / 256 * followerShare / 256 * share / 256
+		torque = limit1(output)
+		if err := sendTorque(int16(torque)); err != nil {
 			log.Print(err)
 		}
-		js.SetButton(2, angle > 32767)
-		js.SetButton(3, angle < -32767)
+		loop.Mark(stageTorque)
+		side := endStop.Side(state.Angle)
+		js.SetButton(2, side > 0)
+		js.SetButton(3, side < 0)
 		js.SetAxis(0, int(limit1(angle)))
 		js.SetAxis(5, int(limit1(angle)))
 		js.SendState()
+		sendPIDStatus()
+		loop.Mark(stageHID)
 	}
 }
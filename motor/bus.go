@@ -0,0 +1,42 @@
+package motor
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrTimeout   = errors.New("can: receive timeout")
+	ErrFrameID   = errors.New("can: frame id out of range")
+	ErrFrameSize = errors.New("can: more than 8 data bytes")
+	ErrTxBusy    = errors.New("can: all transmit buffers busy")
+)
+
+// Frame is a CAN data frame. Ext marks a 29 bit extended ID. Time is set
+// by the receiving Bus.
+type Frame struct {
+	ID   uint32
+	Ext  bool
+	Data []byte
+	Time time.Time
+}
+
+// BusStatus holds the frame and error counters of a Bus.
+type BusStatus struct {
+	TxFrames uint32
+	RxFrames uint32
+	TxErrors uint32
+	RxErrors uint32
+	Pending  bool // a received frame is waiting to be read
+}
+
+// Bus is the CAN controller the motor package works against.
+type Bus interface {
+	// Transmit sends f. The data is copied before Transmit returns.
+	Transmit(f Frame) error
+	// Receive waits for the next frame until deadline and returns ErrTimeout
+	// when it passes. A zero deadline waits forever. The returned frame is
+	// only valid until the next call to Receive.
+	Receive(deadline time.Time) (*Frame, error)
+	Status() BusStatus
+}
@@ -0,0 +1,246 @@
+package motor
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DDT drives the direct drive hub motors this wheel was built around.
+// Torque for motor IDs 1-4 shares frame 0x32 and feedback arrives on
+// 0x96+ID.
+type DDT struct {
+	bus   Bus
+	group *DDTGroup
+	cfg   Config
+	ready bool
+	state MotorState
+}
+
+// DDTGroup addresses up to four DDT motors on one bus. Torque commands are
+// collected per motor and sent as one 0x32 frame by Flush, and streamed
+// feedback is routed to each motor by its ID.
+type DDTGroup struct {
+	bus    Bus
+	motors [4]*DDT
+	cmds   [4]int16
+	auto   bool // flush on every Torque, for a motor on its own
+	buf    [8]byte
+}
+
+func NewDDTGroup(bus Bus) *DDTGroup {
+	return &DDTGroup{bus: bus}
+}
+
+// Add creates the driver of the motor described by cfg.
+func (g *DDTGroup) Add(cfg Config) (*DDT, error) {
+	if cfg.ID < 1 || cfg.ID > 4 {
+		return nil, fmt.Errorf("motor: ddt id %d out of range 1..4", cfg.ID)
+	}
+	if g.motors[cfg.ID-1] != nil {
+		return nil, fmt.Errorf("motor: ddt id %d added twice", cfg.ID)
+	}
+	d := &DDT{bus: g.bus, group: g, cfg: cfg}
+	d.state.position.SetZero(DefaultDDTZero)
+	g.motors[cfg.ID-1] = d
+	return d, nil
+}
+
+// Motor returns the motor with the given ID, or nil.
+func (g *DDTGroup) Motor(id uint8) *DDT {
+	if id < 1 || id > 4 {
+		return nil
+	}
+	return g.motors[id-1]
+}
+
+// Flush sends the torque of all motors in one frame.
+func (g *DDTGroup) Flush() error {
+	for i, pow := range g.cmds {
+		binary.BigEndian.PutUint16(g.buf[i*2:], uint16(-pow))
+	}
+	return g.bus.Transmit(Frame{ID: 0x32, Data: g.buf[:]})
+}
+
+// perMotor builds a setup frame payload with one byte per motor slot.
+func (g *DDTGroup) perMotor(v func(cfg *Config) byte) []byte {
+	b := make([]byte, 8)
+	for i, d := range g.motors {
+		if d != nil {
+			b[i] = v(&d.cfg)
+		}
+	}
+	return b
+}
+
+// poll routes all received feedback frames to their motors.
+func (g *DDTGroup) poll() error {
+	return Drain(g.bus, func(msg *Frame) {
+		id := msg.ID - 0x96
+		if id >= 1 && id <= 4 && g.motors[id-1] != nil && len(msg.Data) >= 8 {
+			g.motors[id-1].decode(msg)
+		}
+	})
+}
+
+// DefaultDDTZero is the center used until a calibrated one is stored.
+const DefaultDDTZero = 600
+
+// DDTQueryOnly is the feedback setting that makes the motor answer 0x107
+// queries instead of reporting on its own.
+const DDTQueryOnly = 0x80
+
+// NewDDT returns the driver of a motor that is alone on its bus. Its
+// torque is sent on every call to Torque.
+func NewDDT(bus Bus, cfg Config) (*DDT, error) {
+	g := NewDDTGroup(bus)
+	g.auto = true
+	return g.Add(cfg)
+}
+
+func (d *DDT) slot() int {
+	return int(d.cfg.ID-1) % 4
+}
+
+func (d *DDT) replyID() uint32 {
+	return 0x96 + uint32(d.cfg.ID)
+}
+
+func (d *DDT) query() Frame {
+	return Frame{ID: 0x107, Data: []byte{d.cfg.ID, 0x01, 0x02, 0x04, 0x55, 0, 0, 0}}
+}
+
+// ddtModeCurrent is the mode byte of the 0x105 frame and of feedback byte 7
+// for the mode that takes the 0x32 torque frame, the 0x00 the wheel has
+// always set the motor to. LoopMode values follow ODrive and don't carry
+// over to the DDT.
+const ddtModeCurrent = 0x00
+
+// SetupSteps describes the setup sequence for the configured motor. The
+// feedback and mode frames carry the settings of every motor of the group,
+// so setting up one motor leaves the others as configured.
+func (d *DDT) SetupSteps() []SetupStep {
+	feedback := d.group.perMotor(func(cfg *Config) byte { return cfg.Feedback })
+	mode := d.group.perMotor(func(cfg *Config) byte { return ddtModeCurrent })
+	query := d.query()
+	checkMode := func(reply []byte) error {
+		if reply[7] != ddtModeCurrent {
+			return fmt.Errorf("in mode %#x, want %#x", reply[7], ddtModeCurrent)
+		}
+		return nil
+	}
+	return []SetupStep{
+		{
+			Name:  "hello",
+			Req:   &Frame{ID: 0x109, Data: make([]byte, 8)},
+			Reply: AnyID,
+		},
+		{
+			Name:  "feedback",
+			Req:   &Frame{ID: 0x106, Data: feedback},
+			Reply: d.replyID(),
+			Size:  8,
+		},
+		{
+			Name:  "mode",
+			Req:   &Frame{ID: 0x105, Data: mode},
+			Reply: d.replyID(),
+			Size:  8,
+			Check: checkMode,
+		},
+		{
+			Name:  "verify",
+			Req:   &query,
+			Reply: d.replyID(),
+			Size:  8,
+			Check: func(reply []byte) error {
+				if err := checkMode(reply); err != nil {
+					return err
+				}
+				if f := decodeDDTFault(reply[6]); f != 0 {
+					return fmt.Errorf("fault %s", f)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Setup runs SetupSteps and enables the torque path only if the motor
+// ends up in current mode.
+func (d *DDT) Setup() error {
+	d.ready = false
+	if d.cfg.Mode != ModeCurrent {
+		return &ModeError{Want: ModeCurrent, Got: d.cfg.Mode}
+	}
+	if err := RunSetup(d.bus, d.SetupSteps()); err != nil {
+		return err
+	}
+	d.ready = true
+	return nil
+}
+
+// State queries the motor, or when it reports on its own, returns the
+// latest sample received without waiting.
+func (d *DDT) State() (*MotorState, error) {
+	if d.cfg.Feedback != DDTQueryOnly {
+		return d.latest()
+	}
+	msg, err := Request(d.bus, StateRetry, d.query(), d.replyID(), 8)
+	if err != nil {
+		return nil, err
+	}
+	d.decode(msg)
+	return &d.state, nil
+}
+
+func (d *DDT) latest() (*MotorState, error) {
+	if err := d.group.poll(); err != nil {
+		return nil, err
+	}
+	if d.state.Time.IsZero() {
+		return nil, ErrNoSample
+	}
+	return &d.state, nil
+}
+
+func (d *DDT) decode(msg *Frame) {
+	d.state.UnmarshalBinary(msg.Data)
+	d.state.Time = stamp(msg)
+	if d.cfg.Invert {
+		d.state.Verocity = -d.state.Verocity
+		d.state.Current = -d.state.Current
+		d.state.Angle = -d.state.Angle
+	}
+}
+
+// Torque sets the torque of this motor. In a group it is sent with the
+// next Flush.
+func (d *DDT) Torque(pow int16) error {
+	if !d.ready && pow != 0 {
+		return ErrNotReady
+	}
+	return d.set(d.cfg.clamp(pow))
+}
+
+func (d *DDT) set(pow int16) error {
+	if d.cfg.Invert {
+		pow = -pow
+	}
+	d.group.cmds[d.slot()] = pow
+	if d.group.auto {
+		return d.group.Flush()
+	}
+	return nil
+}
+
+// Disable commands zero torque at once and closes the torque path until
+// the next Setup.
+func (d *DDT) Disable() error {
+	d.ready = false
+	d.group.cmds[d.slot()] = 0
+	return d.group.Flush()
+}
+
+func (d *DDT) Position() *Position {
+	return &d.state.position
+}
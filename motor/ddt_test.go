@@ -0,0 +1,154 @@
+package motor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeDDT answers every setup frame and query with the feedback of each of
+// its motors, in mode.
+type fakeDDT struct {
+	ids     []uint8
+	mode    byte // feedback byte 7
+	sent    []Frame
+	replies []Frame
+	frame   Frame
+}
+
+func (f *fakeDDT) Transmit(fr Frame) error {
+	f.sent = append(f.sent, Frame{ID: fr.ID, Ext: fr.Ext, Data: append([]byte(nil), fr.Data...)})
+	switch fr.ID {
+	case 0x105, 0x106, 0x109:
+		for _, id := range f.ids {
+			f.reply(id)
+		}
+	case 0x107:
+		f.reply(fr.Data[0])
+	}
+	return nil
+}
+
+func (f *fakeDDT) reply(id uint8) {
+	f.replies = append(f.replies, Frame{ID: 0x96 + uint32(id), Data: ddtFeedback(0, 0, 0, 0, f.mode)})
+}
+
+func (f *fakeDDT) Receive(deadline time.Time) (*Frame, error) {
+	if len(f.replies) == 0 {
+		return nil, ErrTimeout
+	}
+	f.frame, f.replies = f.replies[0], f.replies[1:]
+	return &f.frame, nil
+}
+
+func (f *fakeDDT) Status() BusStatus {
+	return BusStatus{Pending: len(f.replies) > 0}
+}
+
+func TestDDTSetupFrames(t *testing.T) {
+	bus := &fakeDDT{ids: []uint8{2}}
+	d, err := NewDDT(bus, Config{Type: "ddt", ID: 2, Mode: ModeCurrent, Feedback: DDTQueryOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	want := []Frame{
+		{ID: 0x109, Data: []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+		{ID: 0x106, Data: []byte{0, DDTQueryOnly, 0, 0, 0, 0, 0, 0}},
+		{ID: 0x105, Data: []byte{0, 0x00, 0, 0, 0, 0, 0, 0}},
+		{ID: 0x107, Data: []byte{2, 0x01, 0x02, 0x04, 0x55, 0, 0, 0}},
+	}
+	if len(bus.sent) != len(want) {
+		t.Fatalf("sent %d frames, want %d: %v", len(bus.sent), len(want), bus.sent)
+	}
+	for i, w := range want {
+		got := bus.sent[i]
+		if got.ID != w.ID || got.Ext || !bytes.Equal(got.Data, w.Data) {
+			t.Errorf("frame %d = %#x % x, want %#x % x", i, got.ID, got.Data, w.ID, w.Data)
+		}
+	}
+}
+
+func TestDDTSetupRejectsMode(t *testing.T) {
+	bus := &fakeDDT{ids: []uint8{1}}
+	d, _ := NewDDT(bus, Config{Type: "ddt", ID: 1, Mode: ModeVelocity, Feedback: DDTQueryOnly})
+	if err := d.Setup(); err == nil {
+		t.Fatal("setup in velocity mode succeeded")
+	}
+	if err := d.Torque(100); err != ErrNotReady {
+		t.Errorf("Torque = %v, want ErrNotReady", err)
+	}
+	if len(bus.sent) != 0 {
+		t.Errorf("sent %v, want nothing before the mode is checked", bus.sent)
+	}
+}
+
+// TestDDTSetupChecksModeByte checks a motor reporting another mode byte
+// than 0x00 is not driven.
+func TestDDTSetupChecksModeByte(t *testing.T) {
+	bus := &fakeDDT{ids: []uint8{1}, mode: 0x01}
+	d, _ := NewDDT(bus, Config{Type: "ddt", ID: 1, Mode: ModeCurrent, Feedback: DDTQueryOnly})
+	var serr *SetupError
+	if err := d.Setup(); !errors.As(err, &serr) || serr.Step != "mode" {
+		t.Fatalf("Setup = %v, want a mode step error", err)
+	}
+	if err := d.Torque(100); err != ErrNotReady {
+		t.Errorf("Torque = %v, want ErrNotReady", err)
+	}
+}
+
+func TestDDTGroupTorqueSlots(t *testing.T) {
+	bus := &fakeDDT{ids: []uint8{1, 3}}
+	g := NewDDTGroup(bus)
+	m1, _ := g.Add(Config{Type: "ddt", ID: 1, Mode: ModeCurrent, Feedback: DDTQueryOnly})
+	m3, _ := g.Add(Config{Type: "ddt", ID: 3, Mode: ModeCurrent, Feedback: DDTQueryOnly, Invert: true, Limit: 1500})
+	for _, d := range []*DDT{m1, m3} {
+		if err := d.Setup(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bus.sent = nil
+	if err := m1.Torque(1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := m3.Torque(2000); err != nil {
+		t.Fatal(err)
+	}
+	if len(bus.sent) != 0 {
+		t.Fatalf("group sent %d frames before Flush", len(bus.sent))
+	}
+	if err := g.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(bus.sent) != 1 || bus.sent[0].ID != 0x32 || len(bus.sent[0].Data) != 8 {
+		t.Fatalf("sent %v, want one 8 byte 0x32 frame", bus.sent)
+	}
+	b := bus.sent[0].Data
+	// the DDT counts torque against the angle; motor 3 is inverted and
+	// limited to 1500
+	for i, want := range []int16{-1000, 0, 1500, 0} {
+		if got := int16(binary.BigEndian.Uint16(b[i*2:])); got != want {
+			t.Errorf("slot %d = %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestNewDDTFlushesEveryTorque(t *testing.T) {
+	bus := &fakeDDT{ids: []uint8{4}}
+	d, _ := NewDDT(bus, Config{Type: "ddt", ID: 4, Mode: ModeCurrent, Feedback: DDTQueryOnly})
+	if err := d.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	bus.sent = nil
+	d.Torque(-300)
+	if len(bus.sent) != 1 {
+		t.Fatalf("sent %d frames, want 1", len(bus.sent))
+	}
+	if got := int16(binary.BigEndian.Uint16(bus.sent[0].Data[6:])); got != 300 {
+		t.Errorf("slot 3 = %d, want 300", got)
+	}
+}
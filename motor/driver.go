@@ -0,0 +1,53 @@
+package motor
+
+import "fmt"
+
+// Driver speaks the CAN protocol of one kind of servo. Torque takes a
+// command in -32767..32767 of the driver's full scale and returns
+// ErrNotReady until Setup has verified the motor is in torque control.
+type Driver interface {
+	Setup() error
+	State() (*MotorState, error)
+	Torque(pow int16) error
+	Disable() error
+	Position() *Position
+}
+
+// Config selects and parameterizes a Driver.
+type Config struct {
+	Type       string   // "ddt", "odrive" or "vesc"
+	ID         uint8    // motor ID, ODrive node ID or VESC controller ID
+	Mode       LoopMode // torque needs ModeCurrent
+	Feedback   uint8    // DDT: 0x80 reply on query, 1..127 report period in ms
+	Limit      int16    // largest torque command passed on, 0 for full scale
+	Invert     bool     // reverse torque, speed and angle
+	MaxTorque  float32  // ODrive: torque in Nm at full scale
+	MaxCurrent float32  // VESC: current in A at full scale, ODrive: current limit
+	MaxSpeed   float32  // ODrive: velocity limit in turns/s
+	PolePairs  uint8    // VESC: converts ERPM to RPM
+}
+
+func New(bus Bus, cfg Config) (Driver, error) {
+	switch cfg.Type {
+	case "ddt":
+		return NewDDT(bus, cfg)
+	case "odrive":
+		return NewODrive(bus, cfg), nil
+	case "vesc":
+		return NewVESC(bus, cfg), nil
+	}
+	return nil, fmt.Errorf("motor: unknown driver %q", cfg.Type)
+}
+
+// clamp limits pow to the configured torque limit.
+func (cfg *Config) clamp(pow int16) int16 {
+	switch {
+	case cfg.Limit <= 0:
+		return pow
+	case pow > cfg.Limit:
+		return cfg.Limit
+	case pow < -cfg.Limit:
+		return -cfg.Limit
+	}
+	return pow
+}
@@ -0,0 +1,25 @@
+package motor
+
+import "fmt"
+
+// UnexpectedIDError is returned when only frames with other IDs arrived
+// while waiting for a reply.
+type UnexpectedIDError struct {
+	Want uint32
+	Got  uint32
+}
+
+func (e *UnexpectedIDError) Error() string {
+	return fmt.Sprintf("motor: unexpected frame id %#x, want %#x", e.Got, e.Want)
+}
+
+// ShortFrameError is returned when a reply carries fewer bytes than expected.
+type ShortFrameError struct {
+	ID   uint32
+	Len  int
+	Want int
+}
+
+func (e *ShortFrameError) Error() string {
+	return fmt.Sprintf("motor: short frame %#x: %d bytes, want %d", e.ID, e.Len, e.Want)
+}
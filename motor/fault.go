@@ -0,0 +1,63 @@
+package motor
+
+import "strings"
+
+// Fault is a bitmask of conditions reported by a motor.
+type Fault uint8
+
+const (
+	FaultUnderVoltage Fault = 1 << iota
+	FaultOverCurrent
+	FaultOverTemperature
+	FaultStall
+	FaultSensor
+	FaultDriver // any other error the controller reports
+)
+
+// FaultsStop are the faults that require torque to be cut rather than
+// derated.
+const FaultsStop = FaultUnderVoltage | FaultOverCurrent | FaultStall | FaultSensor | FaultDriver
+
+var faultNames = []string{
+	"under-voltage",
+	"over-current",
+	"over-temperature",
+	"stall",
+	"sensor",
+	"driver",
+}
+
+func (f Fault) String() string {
+	if f == 0 {
+		return "ok"
+	}
+	var names []string
+	for i, name := range faultNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ddtFaults maps the bits of the DDT fault byte.
+var ddtFaults = [8]Fault{
+	0: FaultSensor,
+	1: FaultOverCurrent,
+	2: FaultOverCurrent, // phase over-current
+	3: FaultStall,
+	4: FaultOverTemperature,
+	5: FaultUnderVoltage,
+	6: FaultDriver,
+	7: FaultDriver,
+}
+
+func decodeDDTFault(b byte) Fault {
+	var f Fault
+	for i, bit := range ddtFaults {
+		if b&(1<<i) != 0 {
+			f |= bit
+		}
+	}
+	return f
+}
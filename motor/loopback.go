@@ -0,0 +1,70 @@
+package motor
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrQueueFull = errors.New("can: loopback queue full")
+
+// Loopback is an in-memory Bus. Frames transmitted on one end are received
+// on its peer, so motor code can run against a fake device on the host.
+type Loopback struct {
+	peer   *Loopback
+	queue  chan Frame
+	frame  Frame
+	status BusStatus
+}
+
+// NewLoopback returns two connected ends, each buffering up to depth frames.
+func NewLoopback(depth int) (*Loopback, *Loopback) {
+	a := &Loopback{queue: make(chan Frame, depth)}
+	b := &Loopback{queue: make(chan Frame, depth)}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+func (l *Loopback) Transmit(f Frame) error {
+	data := make([]byte, len(f.Data))
+	copy(data, f.Data)
+	select {
+	case l.peer.queue <- Frame{ID: f.ID, Ext: f.Ext, Data: data}:
+		l.status.TxFrames++
+		return nil
+	default:
+		l.status.TxErrors++
+		return ErrQueueFull
+	}
+}
+
+func (l *Loopback) Receive(deadline time.Time) (*Frame, error) {
+	select {
+	case l.frame = <-l.queue:
+		return l.received(), nil
+	default:
+	}
+	if deadline.IsZero() {
+		l.frame = <-l.queue
+		return l.received(), nil
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case l.frame = <-l.queue:
+		return l.received(), nil
+	case <-timer.C:
+		return nil, ErrTimeout
+	}
+}
+
+func (l *Loopback) received() *Frame {
+	l.status.RxFrames++
+	l.frame.Time = time.Now()
+	return &l.frame
+}
+
+func (l *Loopback) Status() BusStatus {
+	s := l.status
+	s.Pending = len(l.queue) > 0
+	return s
+}
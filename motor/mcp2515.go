@@ -0,0 +1,146 @@
+//go:build tinygo
+
+package motor
+
+import (
+	"machine"
+	"runtime"
+	"time"
+
+	"tinygo.org/x/drivers"
+	"tinygo.org/x/drivers/mcp2515"
+)
+
+// MCP2515 adapts a mcp2515.Device to the Bus interface. The driver only
+// writes standard IDs, so frames are loaded into the transmit buffers over
+// spi here instead, which sends extended IDs as well.
+type MCP2515 struct {
+	dev     *mcp2515.Device
+	spi     drivers.SPI
+	cs      machine.Pin
+	tx      [13]byte
+	intPin  machine.Pin
+	pollInt bool
+	frame   Frame
+	data    [8]byte
+	status  BusStatus
+}
+
+// NewMCP2515 returns the adapter of dev, which is connected to spi with
+// chip select cs.
+func NewMCP2515(dev *mcp2515.Device, spi drivers.SPI, cs machine.Pin) *MCP2515 {
+	return &MCP2515{dev: dev, spi: spi, cs: cs}
+}
+
+// PollIntPin makes Receive check the INT pin of the controller instead of
+// its status over SPI, which is much cheaper to poll. The controller holds
+// the pin low while a received frame is waiting, so frames are only read
+// over SPI when there is one. The pin is polled rather than handled in a
+// pin interrupt: a falling edge is missed when a frame arrives while the
+// pin is still low from the previous one, and the SPI bus must not be used
+// from an interrupt handler anyway.
+func (m *MCP2515) PollIntPin(pin machine.Pin) {
+	pin.Configure(machine.PinConfig{Mode: machine.PinInputPullup})
+	m.intPin = pin
+	m.pollInt = true
+}
+
+func (m *MCP2515) received() bool {
+	if m.pollInt {
+		return !m.intPin.Get()
+	}
+	return m.dev.Received()
+}
+
+// Transmit sends f with a standard or an extended ID.
+func (m *MCP2515) Transmit(f Frame) error {
+	if err := m.transmit(f); err != nil {
+		m.status.TxErrors++
+		return err
+	}
+	m.status.TxFrames++
+	return nil
+}
+
+func (m *MCP2515) transmit(f Frame) error {
+	b, err := mcpTxBuffer(m.tx[:0], f)
+	if err != nil {
+		return err
+	}
+	n, err := m.freeTxBuffer()
+	if err != nil {
+		return err
+	}
+	if err := m.command(mcpLoadTx+2*byte(n), b); err != nil {
+		return err
+	}
+	return m.command(mcpRTS|1<<n, nil)
+}
+
+// freeTxBuffer returns a transmit buffer that has no transmission pending,
+// waiting up to about half a millisecond for one.
+func (m *MCP2515) freeTxBuffer() (int, error) {
+	for i := 0; i < 50; i++ {
+		if i > 0 {
+			time.Sleep(10 * time.Microsecond)
+		}
+		status, err := m.readStatus()
+		if err != nil {
+			return 0, err
+		}
+		for n := 0; n < mcpTxBuffers; n++ {
+			if status&mcpTxPending(n) == 0 {
+				return n, nil
+			}
+		}
+	}
+	return 0, ErrTxBusy
+}
+
+// command sends the SPI instruction ins followed by data.
+func (m *MCP2515) command(ins byte, data []byte) error {
+	m.cs.Low()
+	defer m.cs.High()
+	if _, err := m.spi.Transfer(ins); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return m.spi.Tx(data, nil)
+}
+
+func (m *MCP2515) readStatus() (byte, error) {
+	m.cs.Low()
+	defer m.cs.High()
+	if _, err := m.spi.Transfer(mcpReadStatus); err != nil {
+		return 0, err
+	}
+	return m.spi.Transfer(0)
+}
+
+func (m *MCP2515) Receive(deadline time.Time) (*Frame, error) {
+	for !m.received() {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, ErrTimeout
+		}
+		runtime.Gosched()
+	}
+	msg, err := m.dev.Rx()
+	if err != nil {
+		m.status.RxErrors++
+		return nil, err
+	}
+	m.status.RxFrames++
+	m.frame.ID = msg.ID
+	m.frame.Ext = msg.Ext
+	m.frame.Time = time.Now()
+	m.frame.Data = m.data[:copy(m.data[:], msg.Data)]
+	return &m.frame, nil
+}
+
+func (m *MCP2515) Status() BusStatus {
+	s := m.status
+	s.Pending = m.received()
+	return s
+}
@@ -0,0 +1,44 @@
+package motor
+
+// MCP2515 SPI instructions used to transmit.
+const (
+	mcpLoadTx     = 0x40 // + 2*n, load TXBn from TXBnSIDH on
+	mcpRTS        = 0x80 // | 1<<n, request to send TXBn
+	mcpReadStatus = 0xa0
+	mcpTxBuffers  = 3
+)
+
+// mcpTxPending returns the READ STATUS bit of the TXREQ flag of TXBn.
+func mcpTxPending(n int) byte {
+	return 0x04 << (2 * n)
+}
+
+// mcpTxBuffer appends f to b as the registers of a MCP2515 transmit buffer
+// from TXBnSIDH to the last data byte: SIDH, SIDL with the EXIDE flag and
+// the top two bits of an extended ID, EID8, EID0, DLC and the data.
+func mcpTxBuffer(b []byte, f Frame) ([]byte, error) {
+	if len(f.Data) > 8 {
+		return nil, ErrFrameSize
+	}
+	var sid, eid uint32
+	var exide byte
+	if f.Ext {
+		if f.ID >= 1<<29 {
+			return nil, ErrFrameID
+		}
+		sid, eid, exide = f.ID>>18, f.ID&0x3ffff, 0x08
+	} else {
+		if f.ID >= 1<<11 {
+			return nil, ErrFrameID
+		}
+		sid = f.ID
+	}
+	b = append(b,
+		byte(sid>>3),
+		byte(sid&0x07)<<5|exide|byte(eid>>16)&0x03,
+		byte(eid>>8),
+		byte(eid),
+		byte(len(f.Data)),
+	)
+	return append(b, f.Data...), nil
+}
@@ -2,94 +2,140 @@ package motor
 
 import (
 	"encoding/binary"
-	"fmt"
-	"log"
-	"runtime"
+	"errors"
+	"time"
+)
+
+var ErrNoSample = errors.New("motor: no feedback received yet")
+
+// RetryPolicy bounds how long a request waits for its reply and how often
+// it is sent again.
+type RetryPolicy struct {
+	Timeout  time.Duration // per attempt
+	Attempts int
+}
 
-	"tinygo.org/x/drivers/mcp2515"
+var (
+	SetupRetry = RetryPolicy{Timeout: 100 * time.Millisecond, Attempts: 5}
+	StateRetry = RetryPolicy{Timeout: 2 * time.Millisecond, Attempts: 3}
 )
 
-func ReadFrame(can *mcp2515.Device) (*mcp2515.CANMsg, error) {
-	for !can.Received() {
-		runtime.Gosched()
+const AnyID = 0xffffffff
+
+// ReadFrame waits up to timeout for the next frame.
+func ReadFrame(bus Bus, timeout time.Duration) (*Frame, error) {
+	return bus.Receive(time.Now().Add(timeout))
+}
+
+// ReadReply waits up to timeout for a frame with the given id (or AnyID)
+// carrying at least size bytes. Frames with other IDs are discarded.
+func ReadReply(bus Bus, id uint32, size int, timeout time.Duration) (*Frame, error) {
+	deadline := time.Now().Add(timeout)
+	var unexpected error
+	for {
+		msg, err := bus.Receive(deadline)
+		if err == ErrTimeout && unexpected != nil {
+			return nil, unexpected
+		}
+		if err != nil {
+			return nil, err
+		}
+		if id != AnyID && msg.ID != id {
+			unexpected = &UnexpectedIDError{Want: id, Got: msg.ID}
+			continue
+		}
+		if len(msg.Data) < size {
+			return nil, &ShortFrameError{ID: msg.ID, Len: len(msg.Data), Want: size}
+		}
+		return msg, nil
 	}
-	return can.Rx()
 }
 
+// Request transmits req and waits for its reply, retrying according to p.
+// The last error is returned when all attempts fail.
+func Request(bus Bus, p RetryPolicy, req Frame, id uint32, size int) (*Frame, error) {
+	var err error
+	for i := 0; i < p.Attempts; i++ {
+		if err = bus.Transmit(req); err != nil {
+			continue
+		}
+		var msg *Frame
+		if msg, err = ReadReply(bus, id, size, p.Timeout); err == nil {
+			return msg, nil
+		}
+	}
+	return nil, err
+}
+
+// Drain passes every frame already received on bus to handle without
+// waiting for more.
+func Drain(bus Bus, handle func(f *Frame)) error {
+	for {
+		msg, err := bus.Receive(time.Now())
+		if err == ErrTimeout {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		handle(msg)
+	}
+}
+
+// stamp returns when msg was received, falling back to now for buses that
+// do not record it.
+func stamp(msg *Frame) time.Time {
+	if msg.Time.IsZero() {
+		return time.Now()
+	}
+	return msg.Time
+}
+
+// MaxCurrent is the current in A that Current reports as 32767.
+const MaxCurrent = 33
+
 type MotorState struct {
-	Verocity  int16 // -220 .. 220 rpm
-	Current   int16 // -32767 .. 32767 = -33 .. 33 A
-	Angle     int32 // -49151 .. 49151 = -540 .. 540 deg
-	Custom    byte
-	Reserve   byte
-	lastAngle uint16
-	offset    int32
-	angle     uint16 // 0 .. 32767 = 0 .. 360 deg
-	adjust    int32
+	Verocity    int16 // -220 .. 220 rpm, positive while Angle increases
+	Current     int16 // -32767 .. 32767 = -33 .. 33 A
+	Angle       int32 // -49151 .. 49151 = -540 .. 540 deg
+	Temperature int8  // deg C, 0 when the motor does not report it (DDT)
+	Fault       Fault
+	Mode        byte      // DDT loop mode
+	Time        time.Time // when the feedback was received
+	position    Position
 }
 
+// UnmarshalBinary decodes the DDT feedback frame: speed, current, angle,
+// fault byte and mode byte. The DDT counts speed against its angle, so only
+// the angle is negated to keep the two in the same direction. The frame
+// carries no temperature, only the over-temperature bit of the fault byte.
 func (ms *MotorState) UnmarshalBinary(b []byte) error {
-	ms.Verocity = -int16(binary.BigEndian.Uint16(b[0:2]))
+	ms.Verocity = int16(binary.BigEndian.Uint16(b[0:2]))
 	ms.Current = -int16(binary.BigEndian.Uint16(b[2:4]))
-	ms.angle = binary.BigEndian.Uint16(b[4:6]) & 0x7fff
-	ms.Custom = b[6]
-	ms.Reserve = b[7]
-	switch {
-	case ms.lastAngle < 8192 && ms.angle > 24576:
-		ms.offset -= 32767
-	case ms.lastAngle > 24576 && ms.angle < 8192:
-		ms.offset += 32767
-	}
-	ms.Angle = -(int32(ms.angle) + ms.offset + ms.adjust)
-	ms.lastAngle = ms.angle
+	ms.Fault = decodeDDTFault(b[6])
+	ms.Mode = b[7]
+	ms.Angle = -ms.position.Update(binary.BigEndian.Uint16(b[4:6]) & 0x7fff)
 	return nil
 }
 
-func Setup(can *mcp2515.Device) error {
-	if err := can.Tx(0x109, 8, []byte{0, 0, 0, 0, 0, 0, 0, 0}); err != nil {
-		return err
-	}
-	msg, err := ReadFrame(can)
-	if err != nil {
-		return err
-	}
-	log.Printf("%#v", msg)
-	if err := can.Tx(0x106, 8, []byte{0x80, 0, 0, 0, 0, 0, 0, 0}); err != nil {
-		return err
-	}
-	msg, err = ReadFrame(can)
-	if err != nil {
-		return err
-	}
-	log.Printf("%#v", msg)
-	if err := can.Tx(0x105, 8, []byte{0x00, 0, 0, 0, 0, 0, 0, 0}); err != nil {
-		return err
-	}
-	msg, err = ReadFrame(can)
-	if err != nil {
-		return err
-	}
-	log.Printf("%#v", msg)
-	return nil
+// Age returns how old the sample is.
+func (ms *MotorState) Age() time.Duration {
+	return time.Since(ms.Time)
 }
 
-var state = MotorState{adjust: -600}
+func (ms *MotorState) RPM() float32 {
+	return float32(ms.Verocity)
+}
 
-func GetState(can *mcp2515.Device) (*MotorState, error) {
-	if err := can.Tx(0x107, 8, []byte{0x01, 0x01, 0x02, 0x04, 0x55, 0, 0, 0}); err != nil {
-		fmt.Println(err.Error())
-	}
-	msg, err := ReadFrame(can)
-	if err != nil {
-		return nil, err
-	}
-	state.UnmarshalBinary(msg.Data)
-	return &state, nil
+func (ms *MotorState) Amps() float32 {
+	return float32(ms.Current) * MaxCurrent / 32767
 }
 
-var buf = make([]byte, 8)
+func (ms *MotorState) Degrees() float32 {
+	return float32(ms.Angle) * 360 / CountsPerTurn
+}
 
-func Output(can *mcp2515.Device, pow int16) error {
-	binary.BigEndian.PutUint16(buf[0:2], uint16(-pow))
-	return can.Tx(0x32, uint8(len(buf)), buf)
+// Position returns the encoder position the angle is derived from.
+func (ms *MotorState) Position() *Position {
+	return &ms.position
 }
@@ -0,0 +1,56 @@
+package motor
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func ddtFeedback(speed, current int16, angle uint16, fault, mode byte) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint16(b[0:2], uint16(speed))
+	binary.BigEndian.PutUint16(b[2:4], uint16(current))
+	binary.BigEndian.PutUint16(b[4:6], angle)
+	b[6] = fault
+	b[7] = mode
+	return b
+}
+
+func TestMotorStateUnmarshalBinary(t *testing.T) {
+	var ms MotorState
+	if err := ms.UnmarshalBinary(ddtFeedback(-25, 1000, 256, 0x10, 0x01)); err != nil {
+		t.Fatal(err)
+	}
+	if ms.Verocity != -25 {
+		t.Errorf("Verocity = %d, want -25", ms.Verocity)
+	}
+	if ms.Current != -1000 {
+		t.Errorf("Current = %d, want -1000", ms.Current)
+	}
+	if ms.Angle != -256 {
+		t.Errorf("Angle = %d, want -256", ms.Angle)
+	}
+	if ms.Fault != FaultOverTemperature {
+		t.Errorf("Fault = %s, want over-temperature", ms.Fault)
+	}
+	if ms.Mode != 0x01 {
+		t.Errorf("Mode = %#x, want 0x01", ms.Mode)
+	}
+}
+
+func TestMotorStateUnmarshalBinaryUnwraps(t *testing.T) {
+	var ms MotorState
+	for _, tc := range []struct {
+		raw   uint16
+		angle int32
+	}{
+		{100, -100},
+		{32700, 68}, // crossed zero backwards
+		{100, -100},
+		{0x8000 | 200, -200}, // top bit is not part of the angle
+	} {
+		ms.UnmarshalBinary(ddtFeedback(0, 0, tc.raw, 0, 0))
+		if ms.Angle != tc.angle {
+			t.Errorf("raw %d: Angle = %d, want %d", tc.raw, ms.Angle, tc.angle)
+		}
+	}
+}
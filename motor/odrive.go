@@ -0,0 +1,164 @@
+package motor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// ODrive CANSimple command IDs.
+const (
+	odriveHeartbeat         = 0x01
+	odriveSetAxisState      = 0x07
+	odriveEncoderEstimates  = 0x09
+	odriveSetControllerMode = 0x0b
+	odriveSetLimits         = 0x0f
+	odriveSetInputTorque    = 0x0e
+	odriveTemperature       = 0x15
+
+	odriveAxisIdle       = 1
+	odriveAxisClosedLoop = 8
+	odrivePassthrough    = 1
+)
+
+// ODrive drives an ODrive axis over CANSimple in torque control. The axis
+// must broadcast encoder estimates cyclically (encoder_rate_ms).
+type ODrive struct {
+	bus   Bus
+	cfg   Config
+	ready bool
+	state MotorState
+	buf   [8]byte
+}
+
+func NewODrive(bus Bus, cfg Config) *ODrive {
+	return &ODrive{bus: bus, cfg: cfg}
+}
+
+func (o *ODrive) id(cmd uint32) uint32 {
+	return uint32(o.cfg.ID)<<5 | cmd
+}
+
+func (o *ODrive) frame(cmd uint32, args ...uint32) *Frame {
+	b := make([]byte, len(args)*4)
+	for i, a := range args {
+		binary.LittleEndian.PutUint32(b[i*4:], a)
+	}
+	return &Frame{ID: o.id(cmd), Data: b}
+}
+
+// send transmits cmd with one or two little endian 32 bit arguments.
+func (o *ODrive) send(cmd uint32, args ...uint32) error {
+	for i, a := range args {
+		binary.LittleEndian.PutUint32(o.buf[i*4:], a)
+	}
+	return o.bus.Transmit(Frame{ID: o.id(cmd), Data: o.buf[:len(args)*4]})
+}
+
+// SetupSteps describes the setup sequence for the configured axis. The
+// ODrive control modes share their values with LoopMode.
+func (o *ODrive) SetupSteps() []SetupStep {
+	return []SetupStep{
+		{
+			Name:  "limits",
+			Req:   o.frame(odriveSetLimits, math.Float32bits(o.cfg.MaxSpeed), math.Float32bits(o.cfg.MaxCurrent)),
+			Reply: NoReply,
+		},
+		{
+			Name:  "mode",
+			Req:   o.frame(odriveSetControllerMode, uint32(o.cfg.Mode), odrivePassthrough),
+			Reply: NoReply,
+		},
+		{
+			Name:  "closed loop",
+			Req:   o.frame(odriveSetAxisState, odriveAxisClosedLoop),
+			Reply: NoReply,
+		},
+		{
+			Name:  "verify",
+			Reply: o.id(odriveHeartbeat),
+			Size:  5,
+			Check: func(reply []byte) error {
+				if e := binary.LittleEndian.Uint32(reply[0:4]); e != 0 {
+					return fmt.Errorf("axis error %#x", e)
+				}
+				if reply[4] != odriveAxisClosedLoop {
+					return fmt.Errorf("axis state %d", reply[4])
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func (o *ODrive) Setup() error {
+	o.ready = false
+	if err := RunSetup(o.bus, o.SetupSteps()); err != nil {
+		return err
+	}
+	if o.cfg.Mode != ModeCurrent {
+		return &ModeError{Want: ModeCurrent, Got: o.cfg.Mode}
+	}
+	o.ready = true
+	return nil
+}
+
+// State returns the latest encoder estimates without waiting, taking
+// errors and temperature from the heartbeat and temperature messages.
+func (o *ODrive) State() (*MotorState, error) {
+	err := Drain(o.bus, func(msg *Frame) {
+		if msg.Ext || len(msg.Data) < 5 {
+			return
+		}
+		switch {
+		case msg.ID == o.id(odriveHeartbeat):
+			o.state.Fault = 0
+			if binary.LittleEndian.Uint32(msg.Data[0:4]) != 0 {
+				o.state.Fault = FaultDriver
+			}
+		case len(msg.Data) < 8:
+		case msg.ID == o.id(odriveTemperature):
+			motor := math.Float32frombits(binary.LittleEndian.Uint32(msg.Data[4:8]))
+			o.state.Temperature = int8(motor)
+		case msg.ID == o.id(odriveEncoderEstimates):
+			pos := math.Float32frombits(binary.LittleEndian.Uint32(msg.Data[0:4])) // turns
+			vel := math.Float32frombits(binary.LittleEndian.Uint32(msg.Data[4:8])) // turns/s
+			o.state.Verocity = int16(vel * 60)
+			o.state.Angle = o.state.position.Set(int32(pos * CountsPerTurn))
+			if o.cfg.Invert {
+				o.state.Verocity = -o.state.Verocity
+				o.state.Angle = -o.state.Angle
+			}
+			o.state.Time = stamp(msg)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if o.state.Time.IsZero() {
+		return nil, ErrNoSample
+	}
+	return &o.state, nil
+}
+
+func (o *ODrive) Torque(pow int16) error {
+	if !o.ready && pow != 0 {
+		return ErrNotReady
+	}
+	pow = o.cfg.clamp(pow)
+	if o.cfg.Invert {
+		pow = -pow
+	}
+	torque := float32(pow) * o.cfg.MaxTorque / 32767
+	return o.send(odriveSetInputTorque, math.Float32bits(torque))
+}
+
+// Disable puts the axis into idle; Setup must run again to re-enable it.
+func (o *ODrive) Disable() error {
+	o.ready = false
+	return o.send(odriveSetAxisState, odriveAxisIdle)
+}
+
+func (o *ODrive) Position() *Position {
+	return &o.state.position
+}
@@ -0,0 +1,85 @@
+package motor
+
+// CountsPerTurn is the resolution positions are expressed in, matching the
+// 15 bit angle of the DDT feedback.
+const CountsPerTurn = 32768
+
+// Position unwraps a single turn encoder angle into a multi-turn count and
+// applies a calibrated zero offset.
+type Position struct {
+	turns int32
+	last  int32
+	valid bool
+	zero  int32
+}
+
+// Update feeds a single turn reading in 0..CountsPerTurn-1. Moves of less
+// than half a turn between readings are unwrapped exactly. The first
+// reading picks the turn closest to the zero, so a stored zero stays valid
+// across power cycles as long as the wheel starts within half a turn of it.
+func (p *Position) Update(raw uint16) int32 {
+	angle := int32(raw) % CountsPerTurn
+	if p.valid {
+		switch d := angle - p.last; {
+		case d >= CountsPerTurn/2:
+			p.turns--
+		case d < -CountsPerTurn/2:
+			p.turns++
+		}
+	} else {
+		p.turns = floorDiv(p.zero-angle+CountsPerTurn/2, CountsPerTurn)
+	}
+	p.last = angle
+	p.valid = true
+	return p.Value()
+}
+
+// Set feeds an absolute multi-turn count from encoders that track turns
+// themselves.
+func (p *Position) Set(count int32) int32 {
+	p.turns = count / CountsPerTurn
+	p.last = count % CountsPerTurn
+	if p.last < 0 {
+		p.turns--
+		p.last += CountsPerTurn
+	}
+	p.valid = true
+	return p.Value()
+}
+
+// Raw returns the multi-turn count without the zero offset.
+func (p *Position) Raw() int32 {
+	return p.turns*CountsPerTurn + p.last
+}
+
+// Value returns the multi-turn count relative to the zero offset.
+func (p *Position) Value() int32 {
+	return p.Raw() - p.zero
+}
+
+// Turns returns the number of whole revolutions unwrapped so far.
+func (p *Position) Turns() int32 {
+	return p.turns
+}
+
+func (p *Position) Zero() int32 {
+	return p.zero
+}
+
+func (p *Position) SetZero(zero int32) {
+	p.zero = zero
+}
+
+// Center makes the current position the zero and returns the new offset.
+func (p *Position) Center() int32 {
+	p.zero = p.Raw()
+	return p.zero
+}
+
+func floorDiv(a, b int32) int32 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
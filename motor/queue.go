@@ -0,0 +1,100 @@
+package motor
+
+import (
+	"runtime"
+	"time"
+)
+
+// Queue buffers the frames received on a Bus in a ring, so a receive task
+// can empty the controller as soon as frames arrive and the control loop
+// reads them later without waiting. Frames beyond the ring size are
+// dropped and counted.
+//
+// Run and Receive share the ring without a lock. That is only safe because
+// the TinyGo scheduler is cooperative: goroutines switch at sleeps,
+// Gosched and channel operations, none of which happen while Fill or
+// Receive update the ring. Under a preemptive scheduler the ring needs a
+// mutex.
+type Queue struct {
+	bus     Bus
+	ring    []Frame
+	data    [][8]byte
+	head    int // next frame to read
+	n       int
+	frame   Frame
+	dropped uint32
+	failed  uint32 // errors of Fill in Run
+}
+
+func NewQueue(bus Bus, size int) *Queue {
+	return &Queue{
+		bus:  bus,
+		ring: make([]Frame, size),
+		data: make([][8]byte, size),
+	}
+}
+
+// Fill moves all frames pending on the underlying bus into the ring.
+func (q *Queue) Fill() error {
+	for {
+		msg, err := q.bus.Receive(time.Now())
+		if err == ErrTimeout {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if q.n == len(q.ring) {
+			q.dropped++
+			continue
+		}
+		i := (q.head + q.n) % len(q.ring)
+		q.ring[i] = *msg
+		q.ring[i].Data = q.data[i][:copy(q.data[i][:], msg.Data)]
+		q.n++
+	}
+}
+
+// Run fills the queue forever, checking the bus every interval. It is
+// meant to run in its own goroutine; with MCP2515.PollIntPin each check
+// costs only a pin read. Errors are counted as receive errors in Status.
+func (q *Queue) Run(interval time.Duration) {
+	for {
+		if err := q.Fill(); err != nil {
+			q.failed++
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (q *Queue) Transmit(f Frame) error {
+	return q.bus.Transmit(f)
+}
+
+// Receive returns the oldest buffered frame, filling the ring from the
+// underlying bus until deadline when it is empty.
+func (q *Queue) Receive(deadline time.Time) (*Frame, error) {
+	for q.n == 0 {
+		if err := q.Fill(); err != nil {
+			return nil, err
+		}
+		if q.n > 0 {
+			break
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, ErrTimeout
+		}
+		runtime.Gosched()
+	}
+	q.frame = q.ring[q.head]
+	q.head = (q.head + 1) % len(q.ring)
+	q.n--
+	return &q.frame, nil
+}
+
+func (q *Queue) Status() BusStatus {
+	s := q.bus.Status()
+	s.RxErrors += q.dropped + q.failed
+	s.Pending = s.Pending || q.n > 0
+	return s
+}
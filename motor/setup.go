@@ -0,0 +1,103 @@
+package motor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// NoReply marks a setup step whose request is not answered.
+const NoReply = 0xfffffffe
+
+var ErrNotReady = errors.New("motor: torque path disabled, setup not verified")
+
+// LoopMode is the control loop a motor runs in.
+type LoopMode uint8
+
+const (
+	ModeCurrent  LoopMode = 0x01
+	ModeVelocity LoopMode = 0x02
+	ModePosition LoopMode = 0x03
+)
+
+func (m LoopMode) String() string {
+	switch m {
+	case ModeCurrent:
+		return "current"
+	case ModeVelocity:
+		return "velocity"
+	case ModePosition:
+		return "position"
+	}
+	return fmt.Sprintf("mode(%#x)", uint8(m))
+}
+
+// SetupStep is one request of a setup sequence and the reply it must get.
+type SetupStep struct {
+	Name  string
+	Req   *Frame // nil to only wait for the reply
+	Reply uint32 // expected reply ID, AnyID or NoReply
+	Size  int    // minimum reply length
+	Check func(reply []byte) error
+}
+
+// SetupError reports the step a setup sequence failed at.
+type SetupError struct {
+	Step string
+	Err  error
+}
+
+func (e *SetupError) Error() string {
+	return fmt.Sprintf("motor: setup %s: %s", e.Step, e.Err)
+}
+
+func (e *SetupError) Unwrap() error {
+	return e.Err
+}
+
+// ModeError is returned when a motor reports another loop mode than the
+// one it was set up for.
+type ModeError struct {
+	Want LoopMode
+	Got  LoopMode
+}
+
+func (e *ModeError) Error() string {
+	return fmt.Sprintf("motor: in %s mode, want %s", e.Got, e.Want)
+}
+
+// RunSetup runs steps in order, retrying each according to SetupRetry.
+func RunSetup(bus Bus, steps []SetupStep) error {
+	for _, s := range steps {
+		if err := s.run(bus); err != nil {
+			return &SetupError{Step: s.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+func (s SetupStep) run(bus Bus) error {
+	var err error
+	for i := 0; i < SetupRetry.Attempts; i++ {
+		if s.Req != nil {
+			if err = bus.Transmit(*s.Req); err != nil {
+				continue
+			}
+		}
+		if s.Reply == NoReply {
+			return nil
+		}
+		var msg *Frame
+		if msg, err = ReadReply(bus, s.Reply, s.Size, SetupRetry.Timeout); err != nil {
+			continue
+		}
+		if s.Check != nil {
+			if err = s.Check(msg.Data); err != nil {
+				continue
+			}
+		}
+		log.Printf("setup %s: %#x % x", s.Name, msg.ID, msg.Data)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,195 @@
+// Package sim simulates a DDT motor turning a steering shaft. Motor
+// implements motor.Bus, so the motor drivers run against it unchanged.
+package sim
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"diy-ffb-wheel/motor"
+)
+
+const substep = 100 * time.Microsecond
+
+type Config struct {
+	ID        uint8   // motor ID 1..4
+	Inertia   float64 // kg m^2 of rotor and wheel
+	Viscous   float64 // Nm per rad/s
+	Coulomb   float64 // Nm
+	MaxTorque float64 // Nm at command 32767
+}
+
+var DefaultConfig = Config{
+	ID:        1,
+	Inertia:   0.02,
+	Viscous:   0.01,
+	Coulomb:   0.02,
+	MaxTorque: 4.0,
+}
+
+// Motor is a simulated motor. Physics advance to Now() on every frame the
+// controller transmits.
+type Motor struct {
+	Config
+	Now func() time.Time
+	// External adds torque in Nm acting on the shaft, such as a hand or a
+	// mechanical stop. It may be nil.
+	External func(pos, vel float64) float64
+	// Fault is reported as the fault byte of the feedback.
+	Fault byte
+
+	pos, vel float64 // rad, rad/s
+	cmd      int16
+	mode     byte
+	period   time.Duration // feedback report period, 0 to answer queries
+	reported time.Time
+	last     time.Time
+	replies  []motor.Frame
+	frame    motor.Frame
+	status   motor.BusStatus
+}
+
+func New(cfg Config) *Motor {
+	return &Motor{Config: cfg, Now: time.Now}
+}
+
+func (m *Motor) Position() float64 { return m.pos }
+func (m *Motor) Velocity() float64 { return m.vel }
+func (m *Motor) Command() int16    { return m.cmd }
+
+func (m *Motor) SetPosition(pos, vel float64) {
+	m.pos, m.vel = pos, vel
+}
+
+// Torque returns the motor torque in Nm for the current command.
+func (m *Motor) Torque() float64 {
+	return float64(m.cmd) * m.MaxTorque / 32767
+}
+
+// Step integrates the shaft for dt.
+func (m *Motor) Step(dt time.Duration) {
+	for dt > 0 {
+		h := substep
+		if dt < h {
+			h = dt
+		}
+		dt -= h
+		m.integrate(h.Seconds())
+	}
+}
+
+func (m *Motor) integrate(h float64) {
+	tau := m.Torque() - m.Viscous*m.vel
+	if m.External != nil {
+		tau += m.External(m.pos, m.vel)
+	}
+	switch {
+	case m.vel > 0:
+		tau -= m.Coulomb
+	case m.vel < 0:
+		tau += m.Coulomb
+	case math.Abs(tau) <= m.Coulomb:
+		return // held by static friction
+	default:
+		tau -= math.Copysign(m.Coulomb, tau)
+	}
+	vel := m.vel + tau/m.Inertia*h
+	if m.vel != 0 && (vel > 0) != (m.vel > 0) {
+		vel = 0 // friction stops the shaft, it does not reverse it
+	}
+	m.vel = vel
+	m.pos += m.vel * h
+}
+
+func (m *Motor) advance() {
+	now := m.Now()
+	if !m.last.IsZero() {
+		m.Step(now.Sub(m.last))
+	}
+	m.last = now
+}
+
+// feedback encodes the state like the 0x96+ID reply that
+// motor.MotorState.UnmarshalBinary decodes, with the speed counted against
+// the angle as the DDT does.
+func (m *Motor) feedback() motor.Frame {
+	b := make([]byte, 8)
+	rpm := m.vel * 60 / (2 * math.Pi)
+	angle := math.Mod(m.pos/(2*math.Pi), 1)
+	if angle < 0 {
+		angle++
+	}
+	binary.BigEndian.PutUint16(b[0:2], uint16(int16(-rpm)))
+	binary.BigEndian.PutUint16(b[2:4], uint16(m.cmd))
+	binary.BigEndian.PutUint16(b[4:6], uint16(angle*32768)&0x7fff)
+	b[6] = m.Fault
+	b[7] = m.mode
+	return motor.Frame{ID: 0x96 + uint32(m.ID), Data: b}
+}
+
+func (m *Motor) slot(f motor.Frame) (int, bool) {
+	i := int(m.ID-1) % 4
+	return i, m.ID >= 1 && m.ID <= 4 && len(f.Data) > i
+}
+
+// Transmit handles a frame sent by the controller.
+func (m *Motor) Transmit(f motor.Frame) error {
+	m.advance()
+	m.status.TxFrames++
+	i, ok := m.slot(f)
+	switch f.ID {
+	case 0x32:
+		if ok && len(f.Data) >= i*2+2 {
+			m.cmd = int16(binary.BigEndian.Uint16(f.Data[i*2:]))
+		}
+	case 0x105:
+		if ok {
+			m.mode = f.Data[i]
+		}
+		m.replies = append(m.replies, m.feedback())
+	case 0x106:
+		m.period = 0
+		if ok && f.Data[i] != motor.DDTQueryOnly {
+			m.period = time.Duration(f.Data[i]) * time.Millisecond
+		}
+		m.replies = append(m.replies, m.feedback())
+	case 0x109:
+		m.replies = append(m.replies, m.feedback())
+	case 0x107:
+		if len(f.Data) > 0 && f.Data[0] == m.ID {
+			m.replies = append(m.replies, m.feedback())
+		}
+	}
+	return nil
+}
+
+// report queues a feedback frame when the report period has passed.
+func (m *Motor) report() {
+	if m.period == 0 || m.last.Sub(m.reported) < m.period {
+		return
+	}
+	m.reported = m.last
+	m.replies = append(m.replies, m.feedback())
+}
+
+// Receive returns the next pending reply, or motor.ErrTimeout at once when
+// there is none since the simulated motor answers synchronously.
+func (m *Motor) Receive(deadline time.Time) (*motor.Frame, error) {
+	m.advance()
+	m.report()
+	if len(m.replies) == 0 {
+		return nil, motor.ErrTimeout
+	}
+	m.frame = m.replies[0]
+	m.frame.Time = m.last
+	m.replies = m.replies[1:]
+	m.status.RxFrames++
+	return &m.frame, nil
+}
+
+func (m *Motor) Status() motor.BusStatus {
+	s := m.status
+	s.Pending = len(m.replies) > 0
+	return s
+}
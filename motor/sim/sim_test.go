@@ -0,0 +1,159 @@
+package sim_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"diy-ffb-wheel/ffb"
+	"diy-ffb-wheel/motor"
+	"diy-ffb-wheel/motor/sim"
+)
+
+const rate = 500 // Hz, the control loop rate of the wheel
+
+// clock is a fake time source advanced by the test.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// rig is a simulated motor driven by the DDT driver, as in main.
+type rig struct {
+	t   *testing.T
+	clk *clock
+	sim *sim.Motor
+	drv *motor.DDT
+}
+
+func newRig(t *testing.T) *rig {
+	clk := &clock{t: time.Unix(1000, 0)}
+	m := sim.New(sim.DefaultConfig)
+	m.Now = clk.now
+	d, err := motor.NewDDT(m, motor.Config{Type: "ddt", ID: 1, Mode: motor.ModeCurrent, Feedback: motor.DDTQueryOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	return &rig{t: t, clk: clk, sim: m, drv: d}
+}
+
+// place puts the wheel at angle counts, moving at rpm, as the driver sees
+// it. The DDT counts its angle against the shaft and centers at
+// motor.DefaultDDTZero.
+func (r *rig) place(angle int32, rpm float64) {
+	pos := float64(motor.DefaultDDTZero-angle) * 2 * math.Pi / motor.CountsPerTurn
+	r.sim.SetPosition(pos, -rpm*2*math.Pi/60)
+}
+
+// tick advances one loop period and returns the new state.
+func (r *rig) tick() *motor.MotorState {
+	r.clk.advance(time.Second / rate)
+	st, err := r.drv.State()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return st
+}
+
+func clamp(v int32) int16 {
+	switch {
+	case v > 32767:
+		return 32767
+	case v < -32767:
+		return -32767
+	}
+	return int16(v)
+}
+
+func TestTorqueDrivesAngleUp(t *testing.T) {
+	r := newRig(t)
+	r.place(0, 0)
+	start := r.tick().Angle
+	if err := r.drv.Torque(8000); err != nil {
+		t.Fatal(err)
+	}
+	var st *motor.MotorState
+	for i := 0; i < rate/10; i++ {
+		st = r.tick()
+	}
+	if st.Angle <= start || st.Verocity <= 0 {
+		t.Errorf("positive torque moved angle %d -> %d at %d rpm, want both up", start, st.Angle, st.Verocity)
+	}
+	if st.Mode != 0x00 {
+		t.Errorf("mode %#x, want the 0x00 setup puts the motor in", st.Mode)
+	}
+}
+
+// runStop closes the loop of main around the end stop for n ticks and
+// calls check with every state.
+func runStop(r *rig, n int, check func(i int, st *motor.MotorState)) ffb.EndStop {
+	stop := ffb.DefaultEndStop
+	stop.SetRange(540)
+	kin := ffb.NewKinematics(20)
+	for i := 0; i < n; i++ {
+		st := r.tick()
+		kin.Update(st.Time, st.Angle)
+		check(i, st)
+		if err := r.drv.Torque(clamp(stop.Force(st.Angle, kin.RPM()))); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	return stop
+}
+
+// A wheel spun into the stop must be caught within a few degrees and must
+// leave it slower than it came.
+func TestEndStopCatchesWheel(t *testing.T) {
+	const rpm = 60
+	r := newRig(t)
+	r.place(150*motor.CountsPerTurn/360, rpm)
+	peak, exit := int32(0), int16(0)
+	stop := runStop(r, 2*rate, func(i int, st *motor.MotorState) {
+		if st.Angle > peak {
+			peak = st.Angle
+		}
+		if st.Verocity < exit {
+			exit = st.Verocity
+		}
+	})
+	if over := (peak - stop.Limit()) * 360 / motor.CountsPerTurn; over > 15 {
+		t.Errorf("wheel went %d deg past the limit", over)
+	}
+	if exit <= -rpm*3/4 {
+		t.Errorf("wheel bounced off the stop at %d rpm, hit it at %d", exit, rpm)
+	}
+}
+
+// A wheel pushed into the stop by hand must come to rest there rather than
+// oscillate with a growing amplitude.
+func TestEndStopHeldSettles(t *testing.T) {
+	r := newRig(t)
+	r.sim.External = func(pos, vel float64) float64 { return -1 } // 1 Nm towards positive angles
+	r.place(120*motor.CountsPerTurn/360, 0)
+	var swing [6]struct{ lo, hi int32 } // per half second
+	for i := range swing {
+		swing[i].lo, swing[i].hi = math.MaxInt32, math.MinInt32
+	}
+	stop := runStop(r, 3*rate, func(i int, st *motor.MotorState) {
+		s := &swing[i/(rate/2)]
+		if st.Angle < s.lo {
+			s.lo = st.Angle
+		}
+		if st.Angle > s.hi {
+			s.hi = st.Angle
+		}
+	})
+	for i := 3; i < len(swing); i++ {
+		if prev, cur := swing[i-1].hi-swing[i-1].lo, swing[i].hi-swing[i].lo; cur >= prev {
+			t.Errorf("swing grew from %d to %d counts after %d ms", prev, cur, i*500)
+		}
+	}
+	last := swing[len(swing)-1]
+	if last.lo < stop.Limit()-stop.Width || last.hi > stop.Limit()+20*motor.CountsPerTurn/360 {
+		t.Errorf("held wheel at %d..%d, want at the limit %d", last.lo, last.hi, stop.Limit())
+	}
+}
@@ -0,0 +1,123 @@
+package motor
+
+import (
+	"encoding/binary"
+)
+
+// VESC CAN packet IDs.
+const (
+	vescSetCurrent = 1
+	vescStatus     = 9
+	vescStatus4    = 16
+)
+
+// VESC drives a VESC motor controller in current control. Status and
+// Status4 messages must be enabled in the app configuration. VESC uses
+// extended frame IDs.
+type VESC struct {
+	bus   Bus
+	cfg   Config
+	ready bool
+	state MotorState
+	buf   [4]byte
+}
+
+func NewVESC(bus Bus, cfg Config) *VESC {
+	if cfg.PolePairs == 0 {
+		cfg.PolePairs = 1
+	}
+	return &VESC{bus: bus, cfg: cfg}
+}
+
+func (v *VESC) packetID(cmd uint32) uint32 {
+	return cmd<<8 | uint32(v.cfg.ID)
+}
+
+// SetupSteps describes the setup sequence. A VESC needs no configuration
+// over CAN, so setup only checks that it broadcasts its status.
+func (v *VESC) SetupSteps() []SetupStep {
+	return []SetupStep{
+		{
+			Name:  "status",
+			Reply: v.packetID(vescStatus),
+			Size:  8,
+		},
+	}
+}
+
+func (v *VESC) Setup() error {
+	v.ready = false
+	if err := RunSetup(v.bus, v.SetupSteps()); err != nil {
+		return err
+	}
+	if v.cfg.Mode != ModeCurrent {
+		return &ModeError{Want: ModeCurrent, Got: v.cfg.Mode}
+	}
+	v.ready = true
+	return v.send(0)
+}
+
+// State returns the latest Status and Status4 values without waiting.
+// Status4 carries the PID position.
+func (v *VESC) State() (*MotorState, error) {
+	err := Drain(v.bus, func(msg *Frame) {
+		if !msg.Ext || len(msg.Data) < 8 {
+			return
+		}
+		switch msg.ID {
+		case v.packetID(vescStatus):
+			erpm := int32(binary.BigEndian.Uint32(msg.Data[0:4]))
+			amps := int32(int16(binary.BigEndian.Uint16(msg.Data[4:6]))) // A*10
+			v.state.Verocity = int16(erpm / int32(v.cfg.PolePairs))
+			v.state.Current = int16(amps * 32767 / (MaxCurrent * 10))
+			if v.cfg.Invert {
+				v.state.Verocity = -v.state.Verocity
+				v.state.Current = -v.state.Current
+			}
+		case v.packetID(vescStatus4):
+			temp := int16(binary.BigEndian.Uint16(msg.Data[2:4])) // motor deg C*10
+			v.state.Temperature = int8(temp / 10)
+			pos := int32(int16(binary.BigEndian.Uint16(msg.Data[6:8]))) * CountsPerTurn / (360 * 50)
+			v.state.Angle = v.state.position.Update(uint16(pos))
+			if v.cfg.Invert {
+				v.state.Angle = -v.state.Angle
+			}
+			v.state.Time = stamp(msg)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if v.state.Time.IsZero() {
+		return nil, ErrNoSample
+	}
+	return &v.state, nil
+}
+
+func (v *VESC) Torque(pow int16) error {
+	if !v.ready && pow != 0 {
+		return ErrNotReady
+	}
+	pow = v.cfg.clamp(pow)
+	if v.cfg.Invert {
+		pow = -pow
+	}
+	return v.send(pow)
+}
+
+func (v *VESC) send(pow int16) error {
+	ma := int32(float32(pow) * v.cfg.MaxCurrent * 1000 / 32767)
+	binary.BigEndian.PutUint32(v.buf[:], uint32(ma))
+	return v.bus.Transmit(Frame{ID: v.packetID(vescSetCurrent), Ext: true, Data: v.buf[:]})
+}
+
+// Disable commands zero current, which lets the motor coast, and closes
+// the torque path until the next Setup.
+func (v *VESC) Disable() error {
+	v.ready = false
+	return v.send(0)
+}
+
+func (v *VESC) Position() *Position {
+	return &v.state.position
+}
@@ -0,0 +1,33 @@
+package motor
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestVESCSetupOverMCP2515(t *testing.T) {
+	bus, peer := newMCPBus()
+	v := NewVESC(bus, Config{Type: "vesc", ID: 7, Mode: ModeCurrent, MaxCurrent: 20})
+	status := make([]byte, 8)
+	if err := peer.Transmit(Frame{ID: vescStatus<<8 | 7, Ext: true, Data: status}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := peer.Receive(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ID != vescSetCurrent<<8|7 || !f.Ext || len(f.Data) != 4 {
+		t.Fatalf("setup sent %#x ext %v % x, want zero current on %#x", f.ID, f.Ext, f.Data, vescSetCurrent<<8|7)
+	}
+	if err := v.Torque(32767); err != nil {
+		t.Fatal(err)
+	}
+	f, _ = peer.Receive(time.Now())
+	if ma := int32(binary.BigEndian.Uint32(f.Data)); ma != 20000 {
+		t.Errorf("full torque sent %d mA, want 20000", ma)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+
+	"diy-ffb-wheel/motor"
+)
+
+// followerConfigs are further DDT motors on the wheel's bus that get the
+// same torque as the wheel motor, such as the second motor of a dual motor
+// base. Their IDs must differ from motorConfig.ID.
+var followerConfigs = []motor.Config{}
+
+var (
+	group     *motor.DDTGroup // set when the motors share DDT torque frames
+	followers []motor.Driver
+)
+
+// openMotors creates the wheel driver and its followers on bus.
+func openMotors(bus motor.Bus) error {
+	if motorConfig.Type != "ddt" {
+		var err error
+		drv, err = motor.New(bus, motorConfig)
+		return err
+	}
+	group = motor.NewDDTGroup(bus)
+	wheel, err := group.Add(motorConfig)
+	if err != nil {
+		return err
+	}
+	drv = wheel
+	for _, cfg := range followerConfigs {
+		f, err := group.Add(cfg)
+		if err != nil {
+			return err
+		}
+		followers = append(followers, f)
+	}
+	return nil
+}
+
+func setupMotors() error {
+	if err := drv.Setup(); err != nil {
+		return err
+	}
+	for _, f := range followers {
+		if err := f.Setup(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFollowers reads the state of every follower and returns the faults
+// they report and the smallest torque share among them. A follower that
+// does not answer or whose feedback is stale fails the check, so the loop
+// faults as it would for the wheel motor. In query mode this costs one
+// request per follower and tick.
+func checkFollowers() (motor.Fault, int32, error) {
+	fault, share := motor.Fault(0), int32(256)
+	for i, f := range followers {
+		state, err := f.State()
+		if err == nil && state.Age() > StaleAfter {
+			err = errStale
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("follower %d: %w", followerConfigs[i].ID, err)
+		}
+		fault |= state.Fault
+		if s := torqueShare(state); s < share {
+			share = s
+		}
+	}
+	return fault, share, nil
+}
+
+func disableMotors() error {
+	err := drv.Disable()
+	for _, f := range followers {
+		if e := f.Disable(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// sendTorque commands pow to the wheel motor and its followers, in one frame
+// when they share a DDT group.
+func sendTorque(pow int16) error {
+	err := drv.Torque(pow)
+	for _, f := range followers {
+		if e := f.Torque(pow); err == nil {
+			err = e
+		}
+	}
+	if group != nil {
+		if e := group.Flush(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
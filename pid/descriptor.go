@@ -97,7 +97,20 @@ var Descriptor = []byte{
 	0x00, 0xc0, 0x05, 0x0f, 0x09, 0x58, 0xa1, 0x02,
 	0x0b, 0x01, 0x00, 0x0a, 0x00, 0x0b, 0x02, 0x00,
 	0x0a, 0x00, 0x26, 0xfd, 0x7f, 0x75, 0x10, 0x95,
-	0x02, 0x91, 0x02, 0xc0, 0xc0, 0x09, 0x5a, 0xa1,
+	0x02, 0x91, 0x02, 0xc0,
+	0x09, 0xa7, // USAGE (Start Delay)
+	0x66, 0x03, 0x10, // UNIT (Eng Lin:Time)
+	0x55, 0xfd, // UNIT_EXPONENT (-3)
+	0x15, 0x00, // LOGICAL_MINIMUM (0)
+	0x26, 0xff, 0x7f, // LOGICAL_MAXIMUM (32767)
+	0x35, 0x00, // PHYSICAL_MINIMUM (0)
+	0x46, 0xff, 0x7f, // PHYSICAL_MAXIMUM (32767)
+	0x75, 0x10, // REPORT_SIZE (16)
+	0x95, 0x01, // REPORT_COUNT (1)
+	0x91, 0x02, // OUTPUT (Data,Var,Abs)
+	0x66, 0x00, 0x00, // UNIT (None)
+	0x55, 0x00, // UNIT_EXPONENT (0)
+	0xc0, 0x09, 0x5a, 0xa1,
 	0x02, 0x85, 0x02, 0x09, 0x22, 0x15, 0x01, 0x25,
 	0x28, 0x35, 0x01, 0x45, 0x28, 0x75, 0x08, 0x95,
 	0x01, 0x91, 0x02, 0x09, 0x5b, 0x09, 0x5d, 0x16,
@@ -213,4 +226,21 @@ var Descriptor = []byte{
 	0x95, 0x02, 0x15, 0x00, 0x25, 0x01, 0x35, 0x00,
 	0x45, 0x01, 0xb1, 0x02, 0x75, 0x06, 0x95, 0x01,
 	0xb1, 0x03, 0xc0, 0xc0,
+
+	// vendor configuration
+	0x06, 0x00, 0xff, // USAGE_PAGE (Vendor Defined 0xff00)
+	0x09, 0x01, // USAGE (Vendor Usage 1)
+	0xa1, 0x01, // COLLECTION (Application)
+	0x85, 0x20, // REPORT_ID (32)
+	0x09, 0x02, // USAGE (Vendor Usage 2)
+	0x15, 0x00, // LOGICAL_MINIMUM (0)
+	0x26, 0xff, 0x00, // LOGICAL_MAXIMUM (255)
+	0x75, 0x08, // REPORT_SIZE (8)
+	0x95, 0x08, // REPORT_COUNT (8)
+	0xb1, 0x02, // FEATURE (Data/Var/Abs)
+	0x85, 0x21, // REPORT_ID (33)
+	0x09, 0x03, // USAGE (Vendor Usage 3)
+	0x95, 0x03, // REPORT_COUNT (3)
+	0x81, 0x02, // INPUT (Data/Var/Abs)
+	0xc0, // END_COLLECTION
 }
@@ -2,9 +2,6 @@ package pid
 
 import (
 	"fmt"
-	"machine"
-	"machine/usb"
-	"machine/usb/hid"
 	"time"
 )
 
@@ -17,6 +14,14 @@ type PIDHandler struct {
 	paused       bool
 	gain         uint8
 	pidBlockLoad PIDBlockLoadFeatureData
+	vendorSet    func(v VendorFeatureData)
+	vendorGet    func() VendorFeatureData
+	received     uint32
+	samples      [MAX_CUSTOM_SAMPLES]int8 // pool of the custom force samples
+	samplesUsed  uint16
+	customBlock  uint8 // effect the downloaded force samples go to
+	status       PIDStatusInputData
+	reported     [MAX_EFFECTS]bool // playing state last reported per effect
 }
 
 func NewPIDHandler() *PIDHandler {
@@ -27,8 +32,19 @@ func NewPIDHandler() *PIDHandler {
 	return &PIDHandler{
 		effectStates: effects,
 		gains: Gains{
-			TotalGain:    255,
-			ConstantGain: 255,
+			TotalGain:        255,
+			ConstantGain:     255,
+			RampGain:         255,
+			SquareGain:       255,
+			SineGain:         255,
+			TriangleGain:     255,
+			SawtoothDownGain: 255,
+			SawtoothUpGain:   255,
+			SpringGain:       255,
+			DamperGain:       255,
+			InertiaGain:      255,
+			FrictionGain:     255,
+			CustomGain:       255,
 		},
 		params: EffectParams{},
 	}
@@ -42,11 +58,19 @@ func (m *PIDHandler) SetEffectParams(params EffectParams) {
 	m.params = params
 }
 
+// SetVendorHandler installs the callbacks for the vendor feature report.
+// set is called from the USB interrupt.
+func (m *PIDHandler) SetVendorHandler(set func(v VendorFeatureData), get func() VendorFeatureData) {
+	m.vendorSet = set
+	m.vendorGet = get
+}
+
 // from InterruptOut
 func (m *PIDHandler) RxHandler(b []byte) {
 	if len(b) == 0 {
 		return
 	}
+	m.received++
 	reportId := ReportID(b[0])
 	switch reportId {
 	case ReportSetEffect: // 0x01
@@ -78,6 +102,12 @@ func (m *PIDHandler) RxHandler(b []byte) {
 	}
 }
 
+// Received returns the number of output reports received from the host.
+// It wraps around and is only meant to be compared with an earlier value.
+func (m *PIDHandler) Received() uint32 {
+	return m.received
+}
+
 func (m *PIDHandler) CreateNewEffect(data *CreateNewEffectFeatureData) error {
 	m.pidBlockLoad.ReportID = 6
 	m.pidBlockLoad.EffectBlockIndex = m.GetNextFreeEffect()
@@ -85,113 +115,68 @@ func (m *PIDHandler) CreateNewEffect(data *CreateNewEffectFeatureData) error {
 		m.pidBlockLoad.LoadStatus = 2 // 1=Success,2=Full,3=Error
 		return fmt.Errorf("effect not allocated")
 	}
-	m.pidBlockLoad.LoadStatus = 1 // 1=Success,2=Full,3=Error
+	id := m.pidBlockLoad.EffectBlockIndex
+	m.freeSamples(id)
 	effect := TEffectState{}
 	effect.State = MEFFECTSTATE_ALLOCATED
-	m.pidBlockLoad.RamPoolAvailable -= SIZE_EFFECT
-	*m.effectStates[m.pidBlockLoad.EffectBlockIndex] = effect
-	return nil
-}
-
-func (m *PIDHandler) GetReport(setup usb.Setup) bool {
-	reportId := setup.WValueL
-	switch setup.WValueH {
-	case hid.REPORT_TYPE_INPUT:
-	case hid.REPORT_TYPE_OUTPUT:
-	case hid.REPORT_TYPE_FEATURE:
-		switch reportId {
-		case 6:
-			b, _ := m.pidBlockLoad.MarshalBinary()
-			machine.SendUSBInPacket(0, b)
-			return true
-		case 7:
-			b, _ := PIDPoolFeatureData{
-				ReportID:               7,
-				RamPoolSize:            MEMORY_SIZE,
-				MaxSimultaneousEffects: MAX_EFFECTS,
-				MemoryManagement:       3,
-			}.MarshalBinary()
-			machine.SendUSBInPacket(0, b)
-			return true
+	*m.effectStates[id] = effect
+	if data.EffectType == USB_EFFECT_CUSTOM && data.ByteCount > 0 {
+		if !m.reserveSamples(id, data.ByteCount) {
+			m.FreeEffect(id)
+			m.pidBlockLoad.LoadStatus = 2 // 1=Success,2=Full,3=Error
+			return fmt.Errorf("samples not allocated")
 		}
 	}
-	return false
-}
-
-func (m *PIDHandler) GetIdle(setup usb.Setup) bool {
-	machine.SendUSBInPacket(0, []byte{0})
-	return true
-}
-
-func (m *PIDHandler) GetProtocol(setup usb.Setup) bool {
-	machine.SendUSBInPacket(0, []byte{0})
-	return true
+	m.pidBlockLoad.LoadStatus = 1 // 1=Success,2=Full,3=Error
+	m.pidBlockLoad.RamPoolAvailable -= SIZE_EFFECT
+	return nil
 }
 
-func (m *PIDHandler) SetReport(setup usb.Setup) bool {
-	reportId := setup.WValueL
-	switch setup.WValueH {
-	case hid.REPORT_TYPE_INPUT:
-		machine.SendZlp()
-		return true
-	case hid.REPORT_TYPE_OUTPUT:
-		machine.SendZlp()
+// reserveSamples makes the buffer of effect id hold at least n samples. A
+// buffer is taken from the end of the pool and can only grow while it is
+// the last one, which covers hosts that send the samples without saying
+// how many there are.
+func (m *PIDHandler) reserveSamples(id uint8, n uint16) bool {
+	effect := m.effectStates[id]
+	size := uint16(len(effect.samples))
+	if n <= size {
 		return true
-	case hid.REPORT_TYPE_FEATURE:
-		if setup.WLength == 0 {
-			machine.ReceiveUSBControlPacket()
-			machine.SendZlp()
-			return true
-		}
-		if reportId == 5 {
-			b, err := machine.ReceiveUSBControlPacket()
-			if err != nil {
-				return false
-			}
-			v := &CreateNewEffectFeatureData{}
-			v.UnmarshalBinary(b[:])
-			if err := m.CreateNewEffect(v); err != nil {
-				return false
-			}
-			machine.SendZlp()
-			return true
-		}
 	}
-	return false
-}
-
-func (m *PIDHandler) SetIdle(setup usb.Setup) bool {
-	machine.SendZlp()
-	return true
-}
-
-func (m *PIDHandler) SetProtocol(setup usb.Setup) bool {
-	machine.SendZlp()
+	if size == 0 {
+		effect.sampleOffset = m.samplesUsed
+	} else if effect.sampleOffset+size != m.samplesUsed {
+		return false
+	}
+	if int(effect.sampleOffset)+int(n) > MAX_CUSTOM_SAMPLES {
+		return false
+	}
+	effect.samples = m.samples[effect.sampleOffset : effect.sampleOffset+n]
+	m.samplesUsed = effect.sampleOffset + n
+	m.pidBlockLoad.RamPoolAvailable -= n - size
 	return true
 }
 
-func (m *PIDHandler) SetupHandler(setup usb.Setup) bool {
-	switch setup.BmRequestType {
-	case usb.REQUEST_DEVICETOHOST_CLASS_INTERFACE:
-		switch setup.BRequest {
-		case usb.GET_REPORT:
-			return m.GetReport(setup)
-		case usb.GET_IDLE:
-			return m.GetIdle(setup)
-		case usb.GET_PROTOCOL:
-			return m.GetProtocol(setup)
-		}
-	case usb.REQUEST_HOSTTODEVICE_CLASS_INTERFACE:
-		switch setup.BRequest {
-		case usb.SET_REPORT:
-			return m.SetReport(setup)
-		case usb.SET_IDLE:
-			return m.SetIdle(setup)
-		case usb.SET_PROTOCOL:
-			return m.SetProtocol(setup)
+// freeSamples returns the buffer of effect id to the pool. The buffers
+// behind it are moved down so that the free space stays in one piece.
+func (m *PIDHandler) freeSamples(id uint8) {
+	effect := m.effectStates[id]
+	n := uint16(len(effect.samples))
+	if n == 0 {
+		return
+	}
+	off := effect.sampleOffset
+	copy(m.samples[off:], m.samples[off+n:m.samplesUsed])
+	m.samplesUsed -= n
+	effect.samples = nil
+	effect.sampleOffset = 0
+	effect.SampleCount = 0
+	for _, ef := range m.effectStates {
+		if len(ef.samples) > 0 && ef.sampleOffset > off {
+			ef.sampleOffset -= n
+			ef.samples = m.samples[ef.sampleOffset : ef.sampleOffset+uint16(len(ef.samples))]
 		}
 	}
-	return false
+	m.pidBlockLoad.RamPoolAvailable += n
 }
 
 func (m *PIDHandler) GetNextFreeEffect() uint8 {
@@ -219,14 +204,41 @@ func (m *PIDHandler) StopAllEffects() {
 }
 
 func (m *PIDHandler) StartEffect(id uint8) {
+	m.startEffect(id, uint64(time.Now().UnixMilli()))
+}
+
+func (m *PIDHandler) startEffect(id uint8, now uint64) {
 	if id >= MAX_EFFECTS {
 		// unknown id
 		return
 	}
 	effect := m.effectStates[id]
-	effect.State = MEFFECTSTATE_PLAYING
+	effect.State |= MEFFECTSTATE_PLAYING
 	effect.ElapsedTime = 0
-	effect.StartTime = uint64(time.Now().UnixMilli())
+	effect.StartTime = now + uint64(effect.StartDelay)
+}
+
+// Buttons starts the effects whose trigger button is pressed in mask, bit
+// 0 for button 1. A held button starts its effects again every trigger
+// repeat interval.
+func (m *PIDHandler) Buttons(mask uint8) {
+	m.buttons(uint64(time.Now().UnixMilli()), mask)
+}
+
+func (m *PIDHandler) buttons(now uint64, mask uint8) {
+	for id, ef := range m.effectStates {
+		if ef.State == MEFFECTSTATE_FREE || ef.TriggerButton < 1 || ef.TriggerButton > 8 {
+			continue
+		}
+		pressed := mask&(1<<(ef.TriggerButton-1)) != 0
+		switch {
+		case !pressed:
+		case !ef.triggered, ef.TriggerRepeat > 0 && now-ef.triggeredAt >= uint64(ef.TriggerRepeat):
+			m.startEffect(uint8(id), now)
+			ef.triggeredAt = now
+		}
+		ef.triggered = pressed
+	}
 }
 
 func (m *PIDHandler) StopEffect(id uint8) {
@@ -244,6 +256,7 @@ func (m *PIDHandler) FreeAllEffects() {
 	for id := uint8(0); id < MAX_EFFECTS; id++ {
 		*m.effectStates[id] = TEffectState{}
 	}
+	m.samplesUsed = 0
 	m.pidBlockLoad.RamPoolAvailable = MEMORY_SIZE
 }
 
@@ -252,8 +265,10 @@ func (m *PIDHandler) FreeEffect(id uint8) {
 		// unknown id
 		return
 	}
-	state := m.effectStates[id]
-	state.State = MEFFECTSTATE_FREE
+	m.freeSamples(id)
+	// clear the slot so that nothing of the effect, such as its trigger
+	// button, outlives it
+	*m.effectStates[id] = TEffectState{}
 	if id < m.nextEID {
 		m.nextEID = id
 	}
@@ -262,20 +277,30 @@ func (m *PIDHandler) FreeEffect(id uint8) {
 // SetEffect reportId == 0x01
 func (m *PIDHandler) SetEffect(b []byte) {
 	var v SetEffectOutputData
-	_ = v.UnmarshalBinary(b)
+	if err := v.UnmarshalBinary(b); err != nil || int(v.EffectBlockIndex) >= MAX_EFFECTS {
+		return
+	}
 	effect := m.effectStates[v.EffectBlockIndex]
 	effect.Duration = v.Duration
+	effect.StartDelay = v.StartDelay
+	effect.TriggerButton = v.TriggerButton
+	effect.TriggerRepeat = v.TriggerRepeatInterval
 	effect.DirectionX = v.DirectionX
 	effect.DirectionY = v.DirectionY
 	effect.EffectType = v.EffectType
 	effect.Gain = v.Gain
 	effect.EnableAxis = v.EnableAxis
+	if v.SamplePeriod > 0 {
+		effect.SamplePeriod = v.SamplePeriod
+	}
 }
 
 // SetEnvelope reportId == 0x02
 func (m *PIDHandler) SetEnvelope(b []byte) {
 	var v SetEnvelopeOutputData
-	_ = v.UnmarshalBinary(b)
+	if err := v.UnmarshalBinary(b); err != nil || int(v.EffectBlockIndex) >= MAX_EFFECTS {
+		return
+	}
 	effect := m.effectStates[v.EffectBlockIndex]
 	effect.AttackLevel = int16(v.AttackLevel)
 	effect.FadeLevel = v.FadeLevel
@@ -286,26 +311,33 @@ func (m *PIDHandler) SetEnvelope(b []byte) {
 // SetCondition reportId == 0x03
 func (m *PIDHandler) SetCondition(b []byte) {
 	var v SetConditionOutputData
-	_ = v.UnmarshalBinary(b)
+	if err := v.UnmarshalBinary(b); err != nil {
+		return
+	}
 	axis := v.ParameterBlockOffset
+	if int(v.EffectBlockIndex) >= MAX_EFFECTS || axis >= MAX_FFB_AXIS_COUNT {
+		return
+	}
 	effect := m.effectStates[v.EffectBlockIndex]
-	condition := effect.Conditions[axis]
-	condition.CpOffset = v.CpOffset
-	condition.PositiveCoefficient = v.PositiveCoefficient
-	condition.NegativeCoefficient = v.NegativeCoefficient
-	condition.PositiveSaturation = v.PositiveSaturation
-	condition.NegativeSaturation = v.NegativeSaturation
-	condition.DeadBand = v.DeadBand
-	effect.Conditions[axis] = condition
-	if effect.ConditionBlocksCount < axis {
-		effect.ConditionBlocksCount++
+	effect.Conditions[axis] = TEffectCondition{
+		CpOffset:            v.CpOffset,
+		PositiveCoefficient: v.PositiveCoefficient,
+		NegativeCoefficient: v.NegativeCoefficient,
+		PositiveSaturation:  v.PositiveSaturation,
+		NegativeSaturation:  v.NegativeSaturation,
+		DeadBand:            v.DeadBand,
+	}
+	if effect.ConditionBlocksCount <= axis {
+		effect.ConditionBlocksCount = axis + 1
 	}
 }
 
 // SetPeriodic reportId == 0x04
 func (m *PIDHandler) SetPeriodic(b []byte) {
 	var v SetPeriodicOutputData
-	_ = v.UnmarshalBinary(b)
+	if err := v.UnmarshalBinary(b); err != nil || int(v.EffectBlockIndex) >= MAX_EFFECTS {
+		return
+	}
 	effect := m.effectStates[v.EffectBlockIndex]
 	effect.Magnitude = v.Magnitude
 	effect.Offset = v.Offset
@@ -316,7 +348,9 @@ func (m *PIDHandler) SetPeriodic(b []byte) {
 // SetConstantForce reportId == 0x05
 func (m *PIDHandler) SetConstantForce(b []byte) {
 	var v SetConstantForceOutputData
-	_ = v.UnmarshalBinary(b)
+	if err := v.UnmarshalBinary(b); err != nil || int(v.EffectBlockIndex) >= MAX_EFFECTS {
+		return
+	}
 	effect := m.effectStates[v.EffectBlockIndex]
 	effect.Magnitude = v.Magnitude
 }
@@ -324,7 +358,9 @@ func (m *PIDHandler) SetConstantForce(b []byte) {
 // SetRampForce reportId == 0x06
 func (m *PIDHandler) SetRampForce(b []byte) {
 	var v SetRampForceOutputData
-	_ = v.UnmarshalBinary(b)
+	if err := v.UnmarshalBinary(b); err != nil || int(v.EffectBlockIndex) >= MAX_EFFECTS {
+		return
+	}
 	effect := m.effectStates[v.EffectBlockIndex]
 	effect.StartMagnitude = v.StartMagnitude
 	effect.EndMagnitude = v.EndMagnitude
@@ -333,31 +369,47 @@ func (m *PIDHandler) SetRampForce(b []byte) {
 // SetCustomForceData reportId == 0x07
 func (m *PIDHandler) SetCustomForceData(b []byte) {
 	var v SetCustomForceDataOutputData
-	_ = v.UnmarshalBinary(b)
-	// TODO: implement
+	if err := v.UnmarshalBinary(b); err != nil || int(v.EffectBlockIndex) >= MAX_EFFECTS {
+		return
+	}
+	m.customBlock = v.EffectBlockIndex
+	m.reserveSamples(v.EffectBlockIndex, v.DataOffset+uint16(len(v.Data)))
+	effect := m.effectStates[v.EffectBlockIndex]
+	for i, d := range v.Data {
+		n := int(v.DataOffset) + i
+		if n >= len(effect.samples) {
+			break
+		}
+		effect.samples[n] = int8(d)
+		if n >= int(effect.SampleCount) {
+			effect.SampleCount = uint16(n + 1)
+		}
+	}
 }
 
 // SetDownloadForceSample reportId == 0x08
 func (m *PIDHandler) SetDownloadForceSample(b []byte) {
 	var v SetDownloadForceSampleOutputData
-	_ = v.UnmarshalBinary(b)
-	// TODO: implement
+	if err := v.UnmarshalBinary(b); err != nil || m.customBlock == 0 {
+		return
+	}
+	effect := m.effectStates[m.customBlock]
+	if !m.reserveSamples(m.customBlock, effect.SampleCount+1) {
+		return
+	}
+	effect.samples[effect.SampleCount] = v.X
+	effect.SampleCount++
 }
 
 // EffectOperation reportId == 0x0a
 func (m *PIDHandler) EffectOperation(b []byte) {
 	var v EffectOperationOutputData
-	_ = v.UnmarshalBinary(b)
+	if err := v.UnmarshalBinary(b); err != nil || int(v.EffectBlockIndex) >= MAX_EFFECTS {
+		return
+	}
 	switch v.Operation {
 	case EOStart:
-		effect := m.effectStates[v.EffectBlockIndex]
-		switch v.LoopCount {
-		case 0xff:
-			effect.Duration = USB_DURATION_INFINITE
-		default:
-			effect.Duration *= uint16(v.LoopCount)
-		}
-		m.effectStates[v.EffectBlockIndex] = effect
+		m.effectStates[v.EffectBlockIndex].LoopCount = v.LoopCount
 		m.StartEffect(v.EffectBlockIndex)
 	case EOStartSolo:
 		m.StopAllEffects()
@@ -408,22 +460,90 @@ func (m *PIDHandler) DeviceGain(b []byte) {
 // SetCustomForce reportId == 0x0e
 func (m *PIDHandler) SetCustomForce(b []byte) {
 	var v SetCustomForceOutputData
-	_ = v.UnmarshalBinary(b)
-	// TODO: implement
+	if err := v.UnmarshalBinary(b); err != nil || int(v.EffectBlockIndex) >= MAX_EFFECTS {
+		return
+	}
+	m.customBlock = v.EffectBlockIndex
+	m.reserveSamples(v.EffectBlockIndex, uint16(v.SampleCount))
+	effect := m.effectStates[v.EffectBlockIndex]
+	effect.SampleCount = uint16(v.SampleCount)
+	if int(effect.SampleCount) > len(effect.samples) {
+		effect.SampleCount = uint16(len(effect.samples))
+	}
+	if v.SamplePeriod > 0 {
+		effect.SamplePeriod = v.SamplePeriod
+	}
 }
 
-func (m *PIDHandler) CalcForces() []int32 {
-	forces := []int32{0, 0}
+// Playing reports whether any effect is playing.
+func (m *PIDHandler) Playing() bool {
+	if m.paused {
+		return false
+	}
+	now := uint64(time.Now().UnixMilli())
 	for _, ef := range m.effectStates {
-		if ef.State == MEFFECTSTATE_PLAYING &&
-			(ef.Duration == USB_DURATION_INFINITE ||
-				ef.ElapsedTime <= ef.Duration) &&
-			!m.paused {
+		if ef.Active(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusChange returns the next PID state report when the device status or
+// the playing state of an effect changed since the last one returned.
+// flags holds the StatusSafetySwitch, StatusActuatorOverride and
+// StatusActuatorPower bits, which only the caller knows. Effects that
+// changed together are reported by successive calls.
+func (m *PIDHandler) StatusChange(flags uint8) (PIDStatusInputData, bool) {
+	return m.statusChange(uint64(time.Now().UnixMilli()), flags)
+}
+
+func (m *PIDHandler) statusChange(now uint64, flags uint8) (PIDStatusInputData, bool) {
+	status := flags
+	if m.paused {
+		status |= StatusDevicePaused
+	}
+	if m.enabled {
+		status |= StatusActuatorsEnabled
+	}
+	for id, ef := range m.effectStates {
+		playing := !m.paused && ef.Active(now)
+		if playing != m.reported[id] {
+			m.reported[id] = playing
+			m.status = PIDStatusInputData{
+				ReportID:         ReportPIDStatusInputData,
+				Status:           status,
+				EffectBlockIndex: uint8(id),
+				Playing:          playing,
+			}
+			return m.status, true
+		}
+	}
+	if status != m.status.Status {
+		m.status.ReportID = ReportPIDStatusInputData
+		m.status.Status = status
+		return m.status, true
+	}
+	return PIDStatusInputData{}, false
+}
+
+// CalcForces returns the force of the playing effects per axis, split into
+// the streamed effects, whose magnitude the host updates, and the local
+// ones computed from the wheel state and time.
+func (m *PIDHandler) CalcForces() (streamed, local []int32) {
+	streamed, local = []int32{0, 0}, []int32{0, 0}
+	now := uint64(time.Now().UnixMilli())
+	for _, ef := range m.effectStates {
+		if ef.Active(now) && !m.paused {
+			forces := local
+			if ef.Streamed() {
+				forces = streamed
+			}
 			forces[0] += ef.Force(m.gains, m.params, 0)
 			forces[1] += ef.Force(m.gains, m.params, 1)
 		}
 	}
-	return forces
+	return streamed, local
 }
 
 func (m *PIDHandler) GetCurrentEffect() *TEffectState {
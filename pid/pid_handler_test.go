@@ -0,0 +1,562 @@
+package pid
+
+import (
+	"math"
+	"testing"
+)
+
+// newEffect sets up a handler as the host does after a reset and creates
+// an effect of typ with full gain and infinite duration, returning its
+// block index.
+func newEffect(t *testing.T, typ EffectType) (*PIDHandler, uint8) {
+	t.Helper()
+	m := NewPIDHandler()
+	m.FreeAllEffects()
+	if err := m.CreateNewEffect(&CreateNewEffectFeatureData{ReportID: 5, EffectType: typ}); err != nil {
+		t.Fatal(err)
+	}
+	id := m.pidBlockLoad.EffectBlockIndex
+	m.RxHandler(setEffectReport(id, typ, 0, 0, 0))
+	return m, id
+}
+
+// setEffectReport returns a Set Effect report for effect id of typ with
+// full gain and infinite duration, started by button after delay ms and
+// again every repeat ms while the button is held.
+func setEffectReport(id uint8, typ EffectType, button uint8, repeat, delay uint16) []byte {
+	return []byte{
+		0x01, id, byte(typ),
+		0xff, 0x7f, // duration infinite
+		byte(repeat), byte(repeat >> 8),
+		0x00, 0x00, // sample period
+		0xff, // gain
+		button,
+		0x01,       // X axis
+		0x00, 0x00, // direction
+		0x00, 0x00, 0x00, 0x00,
+		byte(delay), byte(delay >> 8),
+	}
+}
+
+func TestSetCondition(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		report []byte // Set Condition report without the report ID and block index
+		metric []float32
+		want   []float32
+	}{
+		{
+			name: "dead band",
+			report: []byte{
+				0x00,       // parameter block offset 0
+				0x00, 0x00, // CP offset 0
+				0x10, 0x27, // positive coefficient 10000
+				0x10, 0x27, // negative coefficient 10000
+				0x10, 0x27, // positive saturation 10000
+				0x10, 0x27, // negative saturation 10000
+				0xe8, 0x03, // dead band 1000
+			},
+			metric: []float32{0.05, -0.0999, 0.3, -0.3},
+			want:   []float32{0, 0, 2000, -2000},
+		},
+		{
+			name: "CP offset",
+			report: []byte{
+				0x00,
+				0xe8, 0x03, // CP offset 1000
+				0x10, 0x27,
+				0x10, 0x27,
+				0x10, 0x27,
+				0x10, 0x27,
+				0x00, 0x00,
+			},
+			metric: []float32{0.1, 0.3, 0},
+			want:   []float32{0, 2000, -1000},
+		},
+		{
+			name: "negative CP offset with dead band",
+			report: []byte{
+				0x00,
+				0x18, 0xfc, // CP offset -1000
+				0x10, 0x27,
+				0x10, 0x27,
+				0x10, 0x27,
+				0x10, 0x27,
+				0xf4, 0x01, // dead band 500
+			},
+			metric: []float32{-0.1, -0.05, -0.2, 0.1},
+			want:   []float32{0, 0, -500, 1500},
+		},
+		{
+			name: "asymmetric coefficients",
+			report: []byte{
+				0x00,
+				0x00, 0x00,
+				0x88, 0x13, // positive coefficient 5000
+				0xd0, 0x07, // negative coefficient 2000
+				0x10, 0x27,
+				0x10, 0x27,
+				0x00, 0x00,
+			},
+			metric: []float32{0.4, -0.4},
+			want:   []float32{2000, -800},
+		},
+		{
+			name: "negative coefficient",
+			report: []byte{
+				0x00,
+				0x00, 0x00,
+				0x78, 0xec, // positive coefficient -5000
+				0x78, 0xec, // negative coefficient -5000
+				0x10, 0x27,
+				0x10, 0x27,
+				0x00, 0x00,
+			},
+			metric: []float32{0.2, -0.2},
+			want:   []float32{-1000, 1000},
+		},
+		{
+			name: "asymmetric saturation",
+			report: []byte{
+				0x00,
+				0x00, 0x00,
+				0x10, 0x27,
+				0x10, 0x27,
+				0xb8, 0x0b, // positive saturation 3000
+				0xf4, 0x01, // negative saturation 500
+				0x00, 0x00,
+			},
+			metric: []float32{0.8, -0.8, 0.2, -0.2},
+			want:   []float32{3000, -500, 2000, -500},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, id := newEffect(t, USB_EFFECT_SPRING)
+			m.RxHandler(append([]byte{0x03, id}, tc.report...))
+			ef := m.effectStates[id]
+			if ef.ConditionBlocksCount != 1 {
+				t.Fatalf("%d condition blocks, want 1", ef.ConditionBlocksCount)
+			}
+			for i, metric := range tc.metric {
+				got := ef.ConditionForceCalculator(metric, ef.Conditions[0])
+				if math.Abs(float64(got-tc.want[i])) > 0.5 {
+					t.Errorf("force at %v = %.1f, want %.0f", metric, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestSetConditionBlockOffset checks the parameter block offset picks the
+// axis the condition applies to.
+func TestSetConditionBlockOffset(t *testing.T) {
+	m, id := newEffect(t, USB_EFFECT_SPRING)
+	x := []byte{0x03, id, 0x00, 0x00, 0x00, 0x10, 0x27, 0x10, 0x27, 0x10, 0x27, 0x10, 0x27, 0x00, 0x00}
+	y := []byte{0x03, id, 0x01, 0x00, 0x00, 0x88, 0x13, 0x88, 0x13, 0xe8, 0x03, 0xe8, 0x03, 0x00, 0x00}
+	m.RxHandler(x)
+	m.RxHandler(y)
+	ef := m.effectStates[id]
+	if ef.ConditionBlocksCount != 2 {
+		t.Fatalf("%d condition blocks, want 2", ef.ConditionBlocksCount)
+	}
+	want := [MAX_FFB_AXIS_COUNT]TEffectCondition{
+		{PositiveCoefficient: 10000, NegativeCoefficient: 10000, PositiveSaturation: 10000, NegativeSaturation: 10000},
+		{PositiveCoefficient: 5000, NegativeCoefficient: 5000, PositiveSaturation: 1000, NegativeSaturation: 1000},
+	}
+	if ef.Conditions != want {
+		t.Errorf("conditions %+v, want %+v", ef.Conditions, want)
+	}
+	// The spring plays on the X axis, with the first block.
+	m.SetEffectParams(EffectParams{SpringPosition: 2000, SpringMaxPosition: 10000})
+	if got := ef.Force(m.gains, m.params, 0); got != 2000*255/256 {
+		t.Errorf("force %d, want %d", got, 2000*255/256)
+	}
+	// An offset past the axes is dropped.
+	m.RxHandler([]byte{0x03, id, 0x02, 0x00, 0x00, 0x10, 0x27, 0x10, 0x27, 0x10, 0x27, 0x10, 0x27, 0x00, 0x00})
+	if ef.ConditionBlocksCount != 2 || ef.Conditions != want {
+		t.Errorf("offset 2 changed the conditions to %+v", ef.Conditions)
+	}
+}
+
+func TestSetPeriodic(t *testing.T) {
+	m, id := newEffect(t, USB_EFFECT_SINE)
+	m.RxHandler([]byte{
+		0x04, id,
+		0x88, 0x13, // magnitude 5000
+		0x30, 0xf8, // offset -2000
+		0x28, 0x23, // phase 9000 (90°)
+		0xe8, 0x03, 0x00, 0x00, // period 1000 ms
+	})
+	ef := m.effectStates[id]
+	if ef.Magnitude != 5000 || ef.Offset != -2000 || ef.Phase != 9000 || ef.Period != 1000 {
+		t.Fatalf("got magnitude %d, offset %d, phase %d, period %d, want 5000, -2000, 9000, 1000",
+			ef.Magnitude, ef.Offset, ef.Phase, ef.Period)
+	}
+	ef.ElapsedTime = 500
+	if got := ef.SineForceCalculator(); math.Abs(float64(got+7000)) > 1 {
+		t.Errorf("force half a period in = %.1f, want -7000", got)
+	}
+}
+
+// press is one call of buttons and whether the effect must be playing and
+// since when after it.
+type press struct {
+	now   uint64
+	mask  uint8
+	start uint64 // 0 when not playing
+}
+
+func TestButtons(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		repeat, delay uint16
+		presses       []press
+	}{
+		{"once per press", 0, 0, []press{
+			{1000, 0x00, 0},
+			{1010, 0x01, 0}, // another button
+			{1020, 0x02, 1020},
+			{1500, 0x02, 1020},
+			{1600, 0x00, 1020},
+			{2000, 0x02, 2000},
+		}},
+		{"start delay", 0, 100, []press{
+			{1000, 0x02, 1100},
+			{1050, 0x02, 1100},
+		}},
+		{"repeat while held", 300, 100, []press{
+			{1000, 0x02, 1100},
+			{1200, 0x02, 1100},
+			{1300, 0x02, 1400},
+			{1500, 0x02, 1400},
+			{1600, 0x02, 1700},
+			{1650, 0x00, 1700},
+			{1700, 0x02, 1800},
+		}},
+		{"repeat from time zero", 300, 100, []press{
+			{0, 0x02, 100},
+			{200, 0x02, 100},
+			{300, 0x02, 400},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, id := newEffect(t, USB_EFFECT_CONSTANT)
+			m.RxHandler(setEffectReport(id, USB_EFFECT_CONSTANT, 2, tc.repeat, tc.delay))
+			ef := m.effectStates[id]
+			for _, p := range tc.presses {
+				m.buttons(p.now, p.mask)
+				playing := ef.State&MEFFECTSTATE_PLAYING != 0
+				if playing != (p.start != 0) || playing && ef.StartTime != p.start {
+					t.Fatalf("at %d ms with buttons %02x: playing %v from %d, want from %d",
+						p.now, p.mask, playing, ef.StartTime, p.start)
+				}
+			}
+		})
+	}
+}
+
+// TestButtonsFreedEffect checks the trigger of an effect ends with it and
+// that free slots are never started.
+func TestButtonsFreedEffect(t *testing.T) {
+	m, id := newEffect(t, USB_EFFECT_CONSTANT)
+	m.RxHandler(setEffectReport(id, USB_EFFECT_CONSTANT, 1, 100, 0))
+	m.buttons(1000, 0x01)
+	m.RxHandler([]byte{0x0b, id}) // block free
+	if ef := m.effectStates[id]; ef.State != MEFFECTSTATE_FREE || ef.TriggerButton != 0 || ef.TriggerRepeat != 0 || ef.triggered {
+		t.Fatalf("freed slot holds %+v", *ef)
+	}
+	// a free slot left with a trigger button, as before a reset
+	m.effectStates[id+1].TriggerButton = 1
+	for now := uint64(1000); now < 2000; now += 50 {
+		m.buttons(now, 0x01)
+	}
+	for i, ef := range m.effectStates {
+		if ef.State != MEFFECTSTATE_FREE {
+			t.Errorf("slot %d in state %d, want free", i, ef.State)
+		}
+	}
+}
+
+// TestStopEffectKeepsSlot checks a stopped effect stays allocated, so its
+// trigger can start it again.
+func TestStopEffectKeepsSlot(t *testing.T) {
+	m, id := newEffect(t, USB_EFFECT_CONSTANT)
+	m.RxHandler(setEffectReport(id, USB_EFFECT_CONSTANT, 1, 0, 0))
+	m.buttons(1000, 0x01)
+	m.RxHandler([]byte{0x0a, id, byte(EOStop), 0})
+	if ef := m.effectStates[id]; ef.State != MEFFECTSTATE_ALLOCATED {
+		t.Fatalf("stopped effect in state %d, want allocated", ef.State)
+	}
+	m.buttons(1100, 0x00)
+	m.buttons(1200, 0x01)
+	if ef := m.effectStates[id]; !ef.Active(1200) {
+		t.Errorf("trigger did not start the stopped effect")
+	}
+}
+
+// newCustom creates a custom force effect reserving n samples.
+func newCustom(t *testing.T, m *PIDHandler, n uint16) *TEffectState {
+	t.Helper()
+	if err := m.CreateNewEffect(&CreateNewEffectFeatureData{ReportID: 5, EffectType: USB_EFFECT_CUSTOM, ByteCount: n}); err != nil {
+		t.Fatal(err)
+	}
+	id := m.pidBlockLoad.EffectBlockIndex
+	m.RxHandler(setEffectReport(id, USB_EFFECT_CUSTOM, 0, 0, 0))
+	return m.effectStates[id]
+}
+
+func fill(ef *TEffectState, v int8) {
+	for i := range ef.samples {
+		ef.samples[i] = v + int8(i)
+	}
+}
+
+func checkFill(t *testing.T, name string, ef *TEffectState, n int, v int8) {
+	t.Helper()
+	for i, s := range ef.samples[:n] {
+		if s != v+int8(i) {
+			t.Fatalf("%s sample %d = %d, want %d", name, i, s, v+int8(i))
+		}
+	}
+}
+
+func TestSamplePool(t *testing.T) {
+	m := NewPIDHandler()
+	m.FreeAllEffects()
+	a, b, c := newCustom(t, m, 10), newCustom(t, m, 20), newCustom(t, m, 30)
+	if a.sampleOffset != 0 || b.sampleOffset != 10 || c.sampleOffset != 30 || m.samplesUsed != 60 {
+		t.Fatalf("offsets %d, %d, %d with %d used, want 0, 10, 30 with 60",
+			a.sampleOffset, b.sampleOffset, c.sampleOffset, m.samplesUsed)
+	}
+	fill(b, 20)
+	fill(c, 50)
+	avail := m.pidBlockLoad.RamPoolAvailable
+	m.FreeEffect(1) // a
+	if b.sampleOffset != 0 || c.sampleOffset != 20 || m.samplesUsed != 50 {
+		t.Fatalf("after freeing the first buffer offsets %d, %d with %d used, want 0, 20 with 50",
+			b.sampleOffset, c.sampleOffset, m.samplesUsed)
+	}
+	if got := m.pidBlockLoad.RamPoolAvailable; got != avail+10 {
+		t.Errorf("pool available %d, want %d", got, avail+10)
+	}
+	checkFill(t, "moved", b, 20, 20)
+	checkFill(t, "moved", c, 30, 50)
+	if &b.samples[0] != &m.samples[0] || &c.samples[0] != &m.samples[20] {
+		t.Error("moved buffers don't point at their new place in the pool")
+	}
+	// only the last buffer can grow
+	if m.reserveSamples(2, 25) {
+		t.Error("grew a buffer with another one behind it")
+	}
+	if !m.reserveSamples(3, 40) || len(c.samples) != 40 || m.samplesUsed != 60 {
+		t.Errorf("growing the last buffer gave %d samples with %d used, want 40 with 60", len(c.samples), m.samplesUsed)
+	}
+	checkFill(t, "grown", c, 30, 50)
+	if m.reserveSamples(3, MAX_CUSTOM_SAMPLES) {
+		t.Error("grew a buffer past the pool")
+	}
+}
+
+func customDataReport(id uint8, offset uint16, data ...int8) []byte {
+	b := []byte{0x07, id, byte(offset), byte(offset >> 8), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	for i, d := range data {
+		b[4+i] = byte(d)
+	}
+	return b
+}
+
+func TestCustomForceData(t *testing.T) {
+	m := NewPIDHandler()
+	m.FreeAllEffects()
+	ef := newCustom(t, m, 0)
+	m.RxHandler(customDataReport(1, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12))
+	m.RxHandler(customDataReport(1, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24))
+	if ef.SampleCount != 24 || len(ef.samples) != 24 {
+		t.Fatalf("%d samples in a buffer of %d, want 24", ef.SampleCount, len(ef.samples))
+	}
+	// rewriting samples keeps the count, the report always carries 12
+	m.RxHandler(customDataReport(1, 4, -1, -2))
+	want := []int8{1, 2, 3, 4, -1, -2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 17}
+	for i, w := range want {
+		if ef.samples[i] != w {
+			t.Fatalf("samples %v, want %v first", ef.samples, want)
+		}
+	}
+	if ef.SampleCount != 24 {
+		t.Errorf("%d samples after a rewrite, want 24", ef.SampleCount)
+	}
+	// 0x08 appends to the effect of the last custom force report
+	m.RxHandler([]byte{0x08, 0x80, 0x00})
+	if ef.SampleCount != 25 || ef.samples[24] != -128 {
+		t.Errorf("appended sample %d of %d, want -128 of 25", ef.samples[len(ef.samples)-1], ef.SampleCount)
+	}
+}
+
+func TestDownloadForceSampleNeedsEffect(t *testing.T) {
+	m := NewPIDHandler()
+	m.FreeAllEffects()
+	ef := newCustom(t, m, 0)
+	m.RxHandler([]byte{0x08, 0x10, 0x00})
+	if ef.SampleCount != 0 || m.samplesUsed != 0 {
+		t.Errorf("sample appended with no custom force report before")
+	}
+}
+
+func TestSetCustomForceClamps(t *testing.T) {
+	m := NewPIDHandler()
+	m.FreeAllEffects()
+	a := newCustom(t, m, 8)
+	newCustom(t, m, 8) // keeps a from growing
+	m.RxHandler([]byte{0x0e, 1, 50, 0x14, 0x00})
+	if a.SampleCount != 8 || a.SamplePeriod != 20 {
+		t.Errorf("got %d samples every %d ms, want 8 every 20", a.SampleCount, a.SamplePeriod)
+	}
+	// a zero period keeps the one set
+	m.RxHandler([]byte{0x0e, 1, 4, 0x00, 0x00})
+	if a.SampleCount != 4 || a.SamplePeriod != 20 {
+		t.Errorf("got %d samples every %d ms, want 4 every 20", a.SampleCount, a.SamplePeriod)
+	}
+}
+
+func TestCustomForcePlayback(t *testing.T) {
+	m := NewPIDHandler()
+	m.FreeAllEffects()
+	ef := newCustom(t, m, 0)
+	m.RxHandler(customDataReport(1, 0, 0, 127, -127))
+	m.RxHandler([]byte{0x0e, 1, 3, 10, 0})
+	for _, tc := range []struct {
+		ms   uint32
+		want float32
+	}{
+		{0, 0}, {5, 5000}, {10, 10000}, {15, 0}, {20, -10000},
+		{25, -5000}, // back towards the first sample
+		{30, 0}, {35, 5000},
+	} {
+		ef.ElapsedTime = tc.ms
+		if got := ef.CustomForceCalculator(); math.Abs(float64(got-tc.want)) > 1 {
+			t.Errorf("force at %d ms = %.1f, want %.0f", tc.ms, got, tc.want)
+		}
+	}
+}
+
+// TestCustomForceSwappedBuffer checks playback of a buffer the USB
+// interrupt freed or shrank under it gives no force instead of panicking.
+func TestCustomForceSwappedBuffer(t *testing.T) {
+	ef := &TEffectState{EffectType: USB_EFFECT_CUSTOM, Gain: 255, SampleCount: 4, SamplePeriod: 10, ElapsedTime: 35}
+	for _, samples := range [][]int8{nil, {1, 2}} {
+		ef.samples = samples
+		if got := ef.CustomForceCalculator(); got != 0 {
+			t.Errorf("force from %d of 4 samples = %.1f, want 0", len(samples), got)
+		}
+	}
+}
+
+// TestReportsCheckBlockIndex checks reports for blocks past the effects
+// are dropped.
+func TestReportsCheckBlockIndex(t *testing.T) {
+	m := NewPIDHandler()
+	m.FreeAllEffects()
+	for _, b := range [][]byte{
+		{0x02, MAX_EFFECTS, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0x05, MAX_EFFECTS, 0x10, 0x27},
+		{0x06, 0xfe, 0x10, 0x27, 0x10, 0x27},
+		{0x0a, MAX_EFFECTS, byte(EOStart), 1},
+		{0x0a, 0xfe, byte(EOStop), 0},
+		{0x05, 1}, // short
+	} {
+		m.RxHandler(b)
+	}
+}
+
+// TestLoopCount checks the loop count of a start sets how long the effect
+// plays without adding up over restarts.
+func TestLoopCount(t *testing.T) {
+	m, id := newEffect(t, USB_EFFECT_CONSTANT)
+	ef := m.effectStates[id]
+	ef.Duration = 100
+	for _, tc := range []struct {
+		loops uint8
+		last  uint64 // ms the effect still plays at
+	}{
+		{3, 300},
+		{3, 300},
+		{1, 100},
+		{0, 100},
+	} {
+		m.RxHandler([]byte{0x0a, id, byte(EOStart), tc.loops})
+		start := ef.StartTime
+		if !ef.Active(start+tc.last) || ef.Active(start+tc.last+1) {
+			t.Errorf("%d loops of 100 ms: want playing for %d ms", tc.loops, tc.last)
+		}
+		if ef.Duration != 100 {
+			t.Fatalf("duration %d after a start, want 100", ef.Duration)
+		}
+	}
+	m.RxHandler([]byte{0x0a, id, byte(EOStart), 0xff})
+	if !ef.Active(ef.StartTime + 1e6) {
+		t.Error("0xff loops stopped playing")
+	}
+}
+
+// TestStatusChange checks every change of the device status or of an
+// effect playing gives exactly one state report.
+func TestStatusChange(t *testing.T) {
+	m := NewPIDHandler()
+	m.FreeAllEffects()
+	for i := 0; i < 2; i++ {
+		if err := m.CreateNewEffect(&CreateNewEffectFeatureData{ReportID: 5, EffectType: USB_EFFECT_CONSTANT}); err != nil {
+			t.Fatal(err)
+		}
+		m.RxHandler(setEffectReport(uint8(i+1), USB_EFFECT_CONSTANT, 0, 0, 0))
+		m.effectStates[i+1].Duration = 100
+	}
+	const power = StatusActuatorPower | StatusSafetySwitch
+	var now uint64
+	type report struct {
+		status  uint8
+		id      uint8
+		playing bool
+	}
+	for _, tc := range []struct {
+		name  string
+		do    func()
+		flags uint8
+		want  []report
+	}{
+		{"idle", func() {}, 0, nil},
+		{"enable", func() { m.RxHandler([]byte{0x0c, byte(ControlEnableActuators)}) }, 0,
+			[]report{{StatusActuatorsEnabled, 0, false}}},
+		{"power", func() {}, power,
+			[]report{{power | StatusActuatorsEnabled, 0, false}}},
+		{"start", func() { m.startEffect(1, now) }, power,
+			[]report{{power | StatusActuatorsEnabled, 1, true}}},
+		{"pause", func() { m.RxHandler([]byte{0x0c, byte(ControlPause)}) }, power,
+			[]report{{power | StatusActuatorsEnabled | StatusDevicePaused, 1, false}}},
+		{"continue", func() { m.RxHandler([]byte{0x0c, byte(ControlContinue)}) }, power,
+			[]report{{power | StatusActuatorsEnabled, 1, true}}},
+		{"ends", func() { now += 101 }, power,
+			[]report{{power | StatusActuatorsEnabled, 1, false}}},
+		{"start both", func() { m.startEffect(1, now); m.startEffect(2, now) }, power,
+			[]report{{power | StatusActuatorsEnabled, 1, true}, {power | StatusActuatorsEnabled, 2, true}}},
+		{"power off", func() {}, 0,
+			[]report{{StatusActuatorsEnabled, 2, true}}},
+	} {
+		tc.do()
+		var got []report
+		for i := 0; i < 4; i++ {
+			s, ok := m.statusChange(now, tc.flags)
+			if !ok {
+				break
+			}
+			got = append(got, report{s.Status, s.EffectBlockIndex, s.Playing})
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: reports %+v, want %+v", tc.name, got, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: reports %+v, want %+v", tc.name, got, tc.want)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package pid
 
 import (
 	"encoding/binary"
+	"errors"
 	"math"
 	"time"
 	"unsafe"
@@ -10,11 +11,14 @@ import (
 const (
 	MAX_EFFECTS        = 10
 	MAX_FFB_AXIS_COUNT = 2
+	MAX_CUSTOM_SAMPLES = 1024 // int8 samples shared by the custom force effects
 )
 
+var ErrShortReport = errors.New("pid: report too short")
+
 var (
 	SIZE_EFFECT = uint16(unsafe.Sizeof(TEffectState{}))
-	MEMORY_SIZE = SIZE_EFFECT * MAX_EFFECTS
+	MEMORY_SIZE = SIZE_EFFECT*MAX_EFFECTS + MAX_CUSTOM_SAMPLES
 )
 
 type ReportID uint8
@@ -41,6 +45,9 @@ const (
 	ReportSetCustomForce         ReportID = 0x0e
 	//Report ReportID = 0x08
 
+	ReportVendor       ReportID = 0x20
+	ReportVendorStatus ReportID = 0x21
+
 	ControlEnableActuators  ControlType = 0x01
 	ControlDisableActuators ControlType = 0x02
 	ControlStopAllEffects   ControlType = 0x03
@@ -69,6 +76,12 @@ const (
 	EOStartSolo EffectOperation = 2
 	EOStop      EffectOperation = 3
 
+	StatusDevicePaused     = 0x01
+	StatusActuatorsEnabled = 0x02
+	StatusSafetySwitch     = 0x04
+	StatusActuatorOverride = 0x08
+	StatusActuatorPower    = 0x10
+
 	X_AXIS_ENABLE     = 0x01
 	Y_AXIS_ENABLE     = 0x02
 	DIRECTION_ENABLE  = 0x04
@@ -83,13 +96,27 @@ const (
 func TO_LT_END_16(x uint16) uint16 { return ((x << 8) & 0xFF00) | ((x >> 8) & 0x00FF) }
 
 func NormalizeRange(x, maxValue int32) float32 {
+	if maxValue == 0 {
+		return 0
+	}
 	return float32(x) / float32(maxValue)
 }
 
 type PIDStatusInputData struct {
 	ReportID         ReportID //2
 	Status           uint8    // Bits: 0=Device Paused,1=Actuators Enabled,2=Safety Switch,3=Actuator Override Switch,4=Actuator Power
-	EffectBlockIndex uint8    // Bit7=Effect Playing, Bit0..7=EffectId (1..40)
+	EffectBlockIndex uint8    // 1..40
+	Playing          bool     // the effect is playing
+}
+
+// MarshalBinary packs the effect playing bit below the block index as the
+// report descriptor lays them out.
+func (s PIDStatusInputData) MarshalBinary() ([]byte, error) {
+	index := s.EffectBlockIndex << 1
+	if s.Playing {
+		index |= 1
+	}
+	return []byte{byte(s.ReportID), s.Status & 0x1f, index}, nil
 }
 
 type SetEffectOutputData struct {
@@ -100,7 +127,7 @@ type SetEffectOutputData struct {
 	TriggerRepeatInterval uint16     // 0..32767 ms
 	SamplePeriod          uint16     // 0..32767 ms
 	Gain                  uint8      // 0..255	 (physical 0..10000)
-	TriggerButton         uint8      // button ID (0..8)
+	TriggerButton         uint8      // button ID (1..8), none otherwise
 	EnableAxis            uint8      // bits: 0=X, 1=Y, 2=DirectionEnable
 	DirectionX            uint8      // angle (0=0 .. 255=360deg)
 	DirectionY            uint8      // angle (0=0 .. 255=360deg)
@@ -108,9 +135,12 @@ type SetEffectOutputData struct {
 }
 
 func (s *SetEffectOutputData) UnmarshalBinary(b []byte) error {
+	if len(b) < 20 {
+		return ErrShortReport
+	}
 	s.ReportID = ReportID(b[0])
-	s.EffectBlockIndex = b[0]
-	s.EffectType = EffectType(b[0])
+	s.EffectBlockIndex = b[1]
+	s.EffectType = EffectType(b[2])
 	s.Duration = binary.LittleEndian.Uint16(b[3:5])
 	s.TriggerRepeatInterval = binary.LittleEndian.Uint16(b[5:7])
 	s.SamplePeriod = binary.LittleEndian.Uint16(b[7:9])
@@ -119,7 +149,8 @@ func (s *SetEffectOutputData) UnmarshalBinary(b []byte) error {
 	s.EnableAxis = b[11]
 	s.DirectionX = b[12]
 	s.DirectionY = b[13]
-	s.StartDelay = binary.LittleEndian.Uint16(b[14:16])
+	// b[14:18] are the type specific block offsets
+	s.StartDelay = binary.LittleEndian.Uint16(b[18:20])
 	return nil
 }
 
@@ -133,6 +164,9 @@ type SetEnvelopeOutputData struct {
 }
 
 func (s *SetEnvelopeOutputData) UnmarshalBinary(b []byte) error {
+	if len(b) < 14 {
+		return ErrShortReport
+	}
 	s.ReportID = ReportID(b[0])
 	s.EffectBlockIndex = b[1]
 	s.AttackLevel = binary.LittleEndian.Uint16(b[2:4])
@@ -145,33 +179,54 @@ func (s *SetEnvelopeOutputData) UnmarshalBinary(b []byte) error {
 type SetConditionOutputData struct {
 	ReportID             ReportID // =3
 	EffectBlockIndex     uint8    // 1..40
-	ParameterBlockOffset uint8    // bits: 0..3=parameterBlockOffset, 4..5=instance1, 6..7=instance2
-	CpOffset             int16    // 0..255
-	PositiveCoefficient  int16    // -128..127
-	NegativeCoefficient  int16    // -128..127
-	PositiveSaturation   int16    // -128..127
-	NegativeSaturation   int16    // -128..127
-	DeadBand             uint16   // 0..255
+	ParameterBlockOffset uint8    // 0..3, the axis the condition is for
+	Instance1            uint8    // 0..3
+	Instance2            uint8    // 0..3
+	CpOffset             int16    // -10000..10000
+	PositiveCoefficient  int16    // -10000..10000
+	NegativeCoefficient  int16    // -10000..10000
+	PositiveSaturation   int16    // 0..10000
+	NegativeSaturation   int16    // 0..10000
+	DeadBand             uint16   // 0..10000
 }
 
 func (s *SetConditionOutputData) UnmarshalBinary(b []byte) error {
+	if len(b) < 15 {
+		return ErrShortReport
+	}
 	s.ReportID = ReportID(b[0])
 	s.EffectBlockIndex = b[1]
+	s.ParameterBlockOffset = b[2] & 0x0f
+	s.Instance1 = b[2] >> 4 & 0x03
+	s.Instance2 = b[2] >> 6 & 0x03
+	s.CpOffset = int16(binary.LittleEndian.Uint16(b[3:5]))
+	s.PositiveCoefficient = int16(binary.LittleEndian.Uint16(b[5:7]))
+	s.NegativeCoefficient = int16(binary.LittleEndian.Uint16(b[7:9]))
+	s.PositiveSaturation = int16(binary.LittleEndian.Uint16(b[9:11]))
+	s.NegativeSaturation = int16(binary.LittleEndian.Uint16(b[11:13]))
+	s.DeadBand = binary.LittleEndian.Uint16(b[13:15])
 	return nil
 }
 
 type SetPeriodicOutputData struct {
 	ReportID         ReportID // =4
 	EffectBlockIndex uint8    // 1..40
-	Magnitude        int16
-	Offset           int16
-	Phase            uint16 // 0..255 (=0..359, exp-2)
-	Period           uint32 // 0..32767 ms
+	Magnitude        int16    // 0..10000
+	Offset           int16    // -10000..10000
+	Phase            uint16   // 0..35999 (=0..359.99 deg)
+	Period           uint32   // 0..32767 ms
 }
 
 func (s *SetPeriodicOutputData) UnmarshalBinary(b []byte) error {
+	if len(b) < 12 {
+		return ErrShortReport
+	}
 	s.ReportID = ReportID(b[0])
 	s.EffectBlockIndex = b[1]
+	s.Magnitude = int16(binary.LittleEndian.Uint16(b[2:4]))
+	s.Offset = int16(binary.LittleEndian.Uint16(b[4:6]))
+	s.Phase = binary.LittleEndian.Uint16(b[6:8])
+	s.Period = binary.LittleEndian.Uint32(b[8:12])
 	return nil
 }
 
@@ -182,6 +237,9 @@ type SetConstantForceOutputData struct {
 }
 
 func (s *SetConstantForceOutputData) UnmarshalBinary(b []byte) error {
+	if len(b) < 4 {
+		return ErrShortReport
+	}
 	s.ReportID = ReportID(b[0])
 	s.EffectBlockIndex = b[1]
 	s.Magnitude = int16(binary.LittleEndian.Uint16(b[2:4]))
@@ -196,6 +254,9 @@ type SetRampForceOutputData struct {
 }
 
 func (s *SetRampForceOutputData) UnmarshalBinary(b []byte) error {
+	if len(b) < 6 {
+		return ErrShortReport
+	}
 	s.ReportID = ReportID(b[0])
 	s.EffectBlockIndex = b[1]
 	s.StartMagnitude = int16(binary.LittleEndian.Uint16(b[2:4]))
@@ -206,11 +267,14 @@ func (s *SetRampForceOutputData) UnmarshalBinary(b []byte) error {
 type SetCustomForceDataOutputData struct {
 	ReportID         ReportID // =7
 	EffectBlockIndex uint8    // 1..40
-	DataOffset       uint16
+	DataOffset       uint16   // 0..10000, sample index of Data[0]
 	Data             [12]byte // int8
 }
 
 func (s *SetCustomForceDataOutputData) UnmarshalBinary(b []byte) error {
+	if len(b) < 16 {
+		return ErrShortReport
+	}
 	s.ReportID = ReportID(b[0])
 	s.EffectBlockIndex = b[1]
 	s.DataOffset = binary.LittleEndian.Uint16(b[2:4])
@@ -225,6 +289,9 @@ type SetDownloadForceSampleOutputData struct {
 }
 
 func (s *SetDownloadForceSampleOutputData) UnmarshalBinary(b []byte) error {
+	if len(b) < 3 {
+		return ErrShortReport
+	}
 	s.ReportID = ReportID(b[0])
 	s.X = int8(b[1])
 	s.Y = int8(b[2])
@@ -239,6 +306,9 @@ type EffectOperationOutputData struct {
 }
 
 func (s *EffectOperationOutputData) UnmarshalBinary(b []byte) error {
+	if len(b) < 4 {
+		return ErrShortReport
+	}
 	s.ReportID = ReportID(b[0])
 	s.EffectBlockIndex = b[1]
 	s.Operation = EffectOperation(b[2])
@@ -289,6 +359,9 @@ type SetCustomForceOutputData struct {
 }
 
 func (s *SetCustomForceOutputData) UnmarshalBinary(b []byte) error {
+	if len(b) < 5 {
+		return ErrShortReport
+	}
 	s.ReportID = ReportID(b[0])
 	s.EffectBlockIndex = b[1]
 	s.SampleCount = b[2]
@@ -341,6 +414,45 @@ func (s PIDPoolFeatureData) MarshalBinary() ([]byte, error) {
 	return b, nil
 }
 
+// VendorFeatureData carries device configuration commands that are not part
+// of the PID spec.
+type VendorFeatureData struct {
+	ReportID ReportID // =32
+	Command  uint8
+	Value    int32
+}
+
+func (s *VendorFeatureData) UnmarshalBinary(b []byte) error {
+	if len(b) < 6 {
+		return ErrShortReport
+	}
+	s.ReportID = ReportID(b[0])
+	s.Command = b[1]
+	s.Value = int32(binary.LittleEndian.Uint32(b[2:6]))
+	return nil
+}
+
+func (s VendorFeatureData) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 9)
+	b = append(b, byte(s.ReportID))
+	b = append(b, s.Command)
+	b = binary.LittleEndian.AppendUint32(b, uint32(s.Value))
+	b = append(b, 0, 0, 0)
+	return b, nil
+}
+
+// VendorStatusInputData reports the state of the control loop.
+type VendorStatusInputData struct {
+	ReportID ReportID // =33
+	State    uint8
+	Cause    uint8 // why State is fault
+	Faults   uint8 // latched safety trips
+}
+
+func (s VendorStatusInputData) MarshalBinary() ([]byte, error) {
+	return []byte{byte(s.ReportID), s.State, s.Cause, s.Faults}, nil
+}
+
 func ApplyGain(value int16, gain uint8) int32 {
 	return int32(value) * int32(gain) / 255
 }
@@ -398,12 +510,12 @@ type Gains struct {
 }
 
 type TEffectCondition struct {
-	CpOffset            int16  // -128..127
-	PositiveCoefficient int16  // -128..127
-	NegativeCoefficient int16  // -128..127
-	PositiveSaturation  int16  // -128..127
-	NegativeSaturation  int16  // -128..127
-	DeadBand            uint16 // 0..255
+	CpOffset            int16  // -10000..10000
+	PositiveCoefficient int16  // -10000..10000
+	NegativeCoefficient int16  // -10000..10000
+	PositiveSaturation  int16  // 0..10000
+	NegativeSaturation  int16  // 0..10000
+	DeadBand            uint16 // 0..10000
 }
 
 type TEffectState struct {
@@ -426,19 +538,57 @@ type TEffectState struct {
 	ConditionBlocksCount uint8
 	Conditions           [MAX_FFB_AXIS_COUNT]TEffectCondition
 	// periodic
-	Phase          uint16 // 0..255 (=0..359, exp-2)
+	Phase          uint16 // 0..35999 (=0..359.99 deg)
 	StartMagnitude int16
 	EndMagnitude   int16
 	Period         uint16 // 0..32767 ms
 	Duration       uint16
-	ElapsedTime    uint16
-	StartTime      uint64
+	LoopCount      uint8  // plays of Duration per start, 0xff until stopped
+	ElapsedTime    uint32 // ms since StartTime, as of the last Force
+	StartTime      uint64 // ms, after the start delay
+	StartDelay     uint16 // ms
+	// trigger
+	TriggerButton uint8  // 1..8, none otherwise
+	TriggerRepeat uint16 // ms, 0 to fire once per press
+	triggered     bool   // trigger button is held
+	triggeredAt   uint64 // ms the trigger button last started the effect
+	// custom force
+	SampleCount  uint16 // samples played back
+	SamplePeriod uint16 // ms per sample
+	samples      []int8 // buffer in the custom sample pool
+	sampleOffset uint16 // start of samples in the pool
+}
+
+// Active reports whether the effect puts out force at now in ms: it is
+// playing, past its start delay and within its duration times its loop
+// count. A loop count of 0, as before the host starts the effect, plays
+// it once.
+func (ef *TEffectState) Active(now uint64) bool {
+	if ef.State&MEFFECTSTATE_PLAYING == 0 || now < ef.StartTime {
+		return false
+	}
+	if ef.Duration == USB_DURATION_INFINITE || ef.LoopCount == 0xff {
+		return true
+	}
+	loops := uint64(ef.LoopCount)
+	if loops == 0 {
+		loops = 1
+	}
+	return now-ef.StartTime <= uint64(ef.Duration)*loops
+}
+
+// Streamed reports whether the force of the effect only changes when the
+// host sends a new magnitude, as games stream constant and ramp forces,
+// rather than being computed on every tick.
+func (ef *TEffectState) Streamed() bool {
+	return ef.EffectType == USB_EFFECT_CONSTANT || ef.EffectType == USB_EFFECT_RAMP
 }
 
 func (ef *TEffectState) Force(gains Gains, params EffectParams, axis uint8) int32 {
 	if axis != 0 {
 		return 0
 	}
+	ef.ElapsedTime = uint32(uint64(time.Now().UnixMilli()) - ef.StartTime)
 	condition := axis
 	const DegToRad = math.Pi / 180
 	force := float32(0.0)
@@ -488,8 +638,8 @@ func (ef *TEffectState) Force(gains Gains, params EffectParams, axis uint8) int3
 		metric := NormalizeRange(params.FrictionPositionChange, params.FrictionMaxPositionChange)
 		force = ef.ConditionForceCalculator(metric, ef.Conditions[condition]) * float32(gains.FrictionGain) / 255.0
 	case USB_EFFECT_CUSTOM: // 12
+		force = ef.CustomForceCalculator() * float32(gains.CustomGain) / 255.0
 	}
-	ef.ElapsedTime = uint16(uint64(time.Now().UnixMilli()) - ef.StartTime)
 	return int32(force * float32(gains.TotalGain) / 256)
 }
 
@@ -502,48 +652,113 @@ func (ef *TEffectState) RampForceCalculator() float32 {
 }
 
 func (ef *TEffectState) SquareForceCalculator() float32 {
-	return 0
+	return ef.periodic(func(x float32) float32 {
+		if x < 0.5 {
+			return 1
+		}
+		return -1
+	})
 }
 
 func (ef *TEffectState) SineForceCalculator() float32 {
-	return 0
+	return ef.periodic(func(x float32) float32 {
+		return float32(math.Sin(2 * math.Pi * float64(x)))
+	})
 }
 
 func (ef *TEffectState) TriangleForceCalculator() float32 {
-	return 0
+	return ef.periodic(func(x float32) float32 {
+		switch {
+		case x < 0.25:
+			return 4 * x
+		case x < 0.75:
+			return 2 - 4*x
+		}
+		return 4*x - 4
+	})
 }
 
 func (ef *TEffectState) SawtoothDownForceCalculator() float32 {
-	return 0
+	return ef.periodic(func(x float32) float32 {
+		return 1 - 2*x
+	})
 }
 
 func (ef *TEffectState) SawtoothUpForceCalculator() float32 {
-	return 0
+	return ef.periodic(func(x float32) float32 {
+		return 2*x - 1
+	})
+}
+
+// periodic returns the offset plus the magnitude times wave, which maps the
+// position within a period, 0..1 shifted by the phase, to -1..1. The waves
+// line up with a sine starting at phase 0.
+func (ef *TEffectState) periodic(wave func(x float32) float32) float32 {
+	force := float32(ef.Offset)
+	if ef.Period > 0 {
+		t := ef.ElapsedTime % uint32(ef.Period)
+		x := float32(t)/float32(ef.Period) + float32(ef.Phase)/36000
+		if x >= 1 {
+			x--
+		}
+		force += float32(ef.Magnitude) * wave(x)
+	}
+	return force * float32(ef.Gain) / 255
 }
 
+// CustomForceCalculator plays the downloaded samples back in a loop, one
+// every sample period, interpolating linearly in between. The samples of
+// -127..127 are scaled to -10000..10000. The output reports that replace
+// the sample buffer arrive in the USB interrupt, so the buffer and its
+// count are read once and checked against each other.
+func (ef *TEffectState) CustomForceCalculator() float32 {
+	samples, n, period := ef.samples, uint64(ef.SampleCount), uint64(ef.SamplePeriod)
+	if n == 0 || period == 0 || n > uint64(len(samples)) {
+		return 0
+	}
+	t := uint64(ef.ElapsedTime) % (period * n)
+	i := t / period
+	s0 := float32(samples[i])
+	s1 := float32(samples[(i+1)%n])
+	x := float32(t%period) / float32(period)
+	force := (s0 + (s1-s0)*x) * 10000 / 127
+	return force * float32(ef.Gain) / 255
+}
+
+// ConditionForceCalculator returns the force of a condition for metric,
+// the position, velocity, acceleration or change normalized to -1..1, on
+// the scale of -10000..10000 of the report. Past the dead band around the
+// center point the force grows with the coefficient of that side up to its
+// saturation, pushing the metric back towards the center. Friction reaches
+// its full force once the wheel moves at 5% of the full scale, so the
+// noise of a wheel at rest leaves it near zero.
 func (ef *TEffectState) ConditionForceCalculator(metric float32, cond TEffectCondition) float32 {
-	tempForce := float32(0)
-	minus := float32(cond.CpOffset) - float32(cond.DeadBand)
-	plus := float32(cond.CpOffset) + float32(cond.DeadBand)
+	const frictionRamp = 500 // metric in 1/10000 where friction is at full force
+	m := metric * 10000
+	lo := float32(cond.CpOffset) - float32(cond.DeadBand)
+	hi := float32(cond.CpOffset) + float32(cond.DeadBand)
+	var d, coef, sat float32
 	switch {
-	case metric < minus:
-		tempForce = (metric - minus/10000) * float32(cond.NegativeCoefficient)
-		if tempForce < -float32(cond.NegativeSaturation) {
-			tempForce = -float32(cond.NegativeSaturation)
-		}
-	case metric > plus:
-		tempForce = (metric - plus/10000) * float32(cond.PositiveCoefficient)
-		if tempForce < float32(cond.PositiveSaturation) {
-			tempForce = float32(cond.PositiveSaturation)
-		}
+	case m < lo:
+		d, coef, sat = m-lo, float32(cond.NegativeCoefficient), float32(cond.NegativeSaturation)
+	case m > hi:
+		d, coef, sat = m-hi, float32(cond.PositiveCoefficient), float32(cond.PositiveSaturation)
 	default:
 		return 0
 	}
-	tempForce = -tempForce * float32(ef.Gain) / 255
-	switch ef.EffectType {
-	case USB_EFFECT_DAMPER:
-	case USB_EFFECT_INERTIA:
-	case USB_EFFECT_FRICTION:
+	if ef.EffectType == USB_EFFECT_FRICTION {
+		d = clamp(d*10000/frictionRamp, 10000)
+	}
+	force := clamp(coef*d/10000, sat)
+	return force * float32(ef.Gain) / 255
+}
+
+func clamp(v, limit float32) float32 {
+	switch {
+	case v > limit:
+		return limit
+	case v < -limit:
+		return -limit
 	}
-	return tempForce
+	return v
 }
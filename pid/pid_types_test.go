@@ -0,0 +1,130 @@
+package pid
+
+import (
+	"math"
+	"testing"
+)
+
+// waveTimes are the ms into a period of 1000 ms the waves are sampled at.
+var waveTimes = []uint32{0, 125, 250, 375, 500, 625, 750, 875}
+
+func TestPeriodicWaves(t *testing.T) {
+	const s = 7071.07 // 10000 * sin(45°)
+	for _, tc := range []struct {
+		name      string
+		typ       EffectType
+		magnitude int16
+		offset    int16
+		phase     uint16
+		gain      uint8
+		want      []float32
+	}{
+		{"square", USB_EFFECT_SQUARE, 10000, 0, 0, 255,
+			[]float32{10000, 10000, 10000, 10000, -10000, -10000, -10000, -10000}},
+		{"sine", USB_EFFECT_SINE, 10000, 0, 0, 255,
+			[]float32{0, s, 10000, s, 0, -s, -10000, -s}},
+		{"triangle", USB_EFFECT_TRIANGLE, 10000, 0, 0, 255,
+			[]float32{0, 5000, 10000, 5000, 0, -5000, -10000, -5000}},
+		{"sawtooth down", USB_EFFECT_SAWTOOTHDOWN, 10000, 0, 0, 255,
+			[]float32{10000, 7500, 5000, 2500, 0, -2500, -5000, -7500}},
+		{"sawtooth up", USB_EFFECT_SAWTOOTHUP, 10000, 0, 0, 255,
+			[]float32{-10000, -7500, -5000, -2500, 0, 2500, 5000, 7500}},
+		{"sine phase 90", USB_EFFECT_SINE, 10000, 0, 9000, 255,
+			[]float32{10000, s, 0, -s, -10000, -s, 0, s}},
+		{"square phase 270", USB_EFFECT_SQUARE, 10000, 0, 27000, 255,
+			[]float32{-10000, -10000, 10000, 10000, 10000, 10000, -10000, -10000}},
+		{"triangle phase 180", USB_EFFECT_TRIANGLE, 10000, 0, 18000, 255,
+			[]float32{0, -5000, -10000, -5000, 0, 5000, 10000, 5000}},
+		{"sawtooth up phase 90", USB_EFFECT_SAWTOOTHUP, 10000, 0, 9000, 255,
+			[]float32{-5000, -2500, 0, 2500, 5000, 7500, -10000, -7500}},
+		{"sine offset", USB_EFFECT_SINE, 5000, 2000, 0, 255,
+			[]float32{2000, 2000 + s/2, 7000, 2000 + s/2, 2000, 2000 - s/2, -3000, 2000 - s/2}},
+		{"sawtooth down offset", USB_EFFECT_SAWTOOTHDOWN, 4000, -3000, 0, 255,
+			[]float32{1000, 0, -1000, -2000, -3000, -4000, -5000, -6000}},
+		{"square half gain", USB_EFFECT_SQUARE, 10000, 0, 0, 51,
+			[]float32{2000, 2000, 2000, 2000, -2000, -2000, -2000, -2000}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ef := &TEffectState{
+				EffectType: tc.typ,
+				Magnitude:  tc.magnitude,
+				Offset:     tc.offset,
+				Phase:      tc.phase,
+				Gain:       tc.gain,
+				Period:     1000,
+			}
+			for i, ms := range waveTimes {
+				// a later period, past the 16 bits of ms of the report
+				for _, elapsed := range []uint32{ms, 70000 + ms} {
+					ef.ElapsedTime = elapsed
+					if got := periodicForce(ef); math.Abs(float64(got-tc.want[i])) > 1 {
+						t.Errorf("force at %d ms = %.1f, want %.1f", elapsed, got, tc.want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func periodicForce(ef *TEffectState) float32 {
+	switch ef.EffectType {
+	case USB_EFFECT_SQUARE:
+		return ef.SquareForceCalculator()
+	case USB_EFFECT_SINE:
+		return ef.SineForceCalculator()
+	case USB_EFFECT_TRIANGLE:
+		return ef.TriangleForceCalculator()
+	case USB_EFFECT_SAWTOOTHDOWN:
+		return ef.SawtoothDownForceCalculator()
+	case USB_EFFECT_SAWTOOTHUP:
+		return ef.SawtoothUpForceCalculator()
+	}
+	return 0
+}
+
+// TestPeriodicNoPeriod checks an effect without a period holds its offset.
+func TestPeriodicNoPeriod(t *testing.T) {
+	ef := &TEffectState{EffectType: USB_EFFECT_SINE, Magnitude: 10000, Offset: -1500, Gain: 255, ElapsedTime: 250}
+	if got := ef.SineForceCalculator(); got != -1500 {
+		t.Errorf("force %.1f, want -1500", got)
+	}
+}
+
+// TestFrictionRamp checks friction only reaches its full force once the
+// wheel clearly moves, so jitter around rest stays small.
+func TestFrictionRamp(t *testing.T) {
+	ef := &TEffectState{EffectType: USB_EFFECT_FRICTION, Gain: 255}
+	cond := TEffectCondition{PositiveCoefficient: 10000, NegativeCoefficient: 10000, PositiveSaturation: 10000, NegativeSaturation: 10000}
+	for _, tc := range []struct {
+		metric float32
+		want   float32
+	}{
+		{0.0001, 20},
+		{-0.0001, -20},
+		{0.01, 2000},
+		{0.025, 5000},
+		{0.05, 10000},
+		{-0.5, -10000},
+	} {
+		if got := ef.ConditionForceCalculator(tc.metric, cond); math.Abs(float64(got-tc.want)) > 1 {
+			t.Errorf("friction at %v = %.1f, want %.0f", tc.metric, got, tc.want)
+		}
+	}
+}
+
+func TestStatusMarshal(t *testing.T) {
+	for _, tc := range []struct {
+		in   PIDStatusInputData
+		want []byte
+	}{
+		{PIDStatusInputData{ReportID: 2, Status: StatusActuatorsEnabled, EffectBlockIndex: 3, Playing: true}, []byte{2, 0x02, 0x07}},
+		{PIDStatusInputData{ReportID: 2, Status: StatusActuatorsEnabled, EffectBlockIndex: 3}, []byte{2, 0x02, 0x06}},
+		{PIDStatusInputData{ReportID: 2, Status: 0xff, EffectBlockIndex: 0x7f, Playing: true}, []byte{2, 0x1f, 0xff}},
+		{PIDStatusInputData{ReportID: 2, Status: StatusDevicePaused | StatusActuatorPower}, []byte{2, 0x11, 0x00}},
+	} {
+		got, _ := tc.in.MarshalBinary()
+		if string(got) != string(tc.want) {
+			t.Errorf("%+v = % x, want % x", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+//go:build tinygo
+
+package pid
+
+import (
+	"machine"
+	"machine/usb"
+	"machine/usb/hid"
+)
+
+func (m *PIDHandler) GetReport(setup usb.Setup) bool {
+	reportId := setup.WValueL
+	switch setup.WValueH {
+	case hid.REPORT_TYPE_INPUT:
+	case hid.REPORT_TYPE_OUTPUT:
+	case hid.REPORT_TYPE_FEATURE:
+		switch reportId {
+		case 6:
+			b, _ := m.pidBlockLoad.MarshalBinary()
+			machine.SendUSBInPacket(0, b)
+			return true
+		case 7:
+			b, _ := PIDPoolFeatureData{
+				ReportID:               7,
+				RamPoolSize:            MEMORY_SIZE,
+				MaxSimultaneousEffects: MAX_EFFECTS,
+				MemoryManagement:       3,
+			}.MarshalBinary()
+			machine.SendUSBInPacket(0, b)
+			return true
+		case uint8(ReportVendor):
+			if m.vendorGet == nil {
+				return false
+			}
+			v := m.vendorGet()
+			v.ReportID = ReportVendor
+			b, _ := v.MarshalBinary()
+			machine.SendUSBInPacket(0, b)
+			return true
+		}
+	}
+	return false
+}
+
+func (m *PIDHandler) GetIdle(setup usb.Setup) bool {
+	machine.SendUSBInPacket(0, []byte{0})
+	return true
+}
+
+func (m *PIDHandler) GetProtocol(setup usb.Setup) bool {
+	machine.SendUSBInPacket(0, []byte{0})
+	return true
+}
+
+func (m *PIDHandler) SetReport(setup usb.Setup) bool {
+	reportId := setup.WValueL
+	switch setup.WValueH {
+	case hid.REPORT_TYPE_INPUT:
+		machine.SendZlp()
+		return true
+	case hid.REPORT_TYPE_OUTPUT:
+		machine.SendZlp()
+		return true
+	case hid.REPORT_TYPE_FEATURE:
+		if setup.WLength == 0 {
+			machine.ReceiveUSBControlPacket()
+			machine.SendZlp()
+			return true
+		}
+		if reportId == 5 {
+			b, err := machine.ReceiveUSBControlPacket()
+			if err != nil {
+				return false
+			}
+			v := &CreateNewEffectFeatureData{}
+			v.UnmarshalBinary(b[:])
+			if err := m.CreateNewEffect(v); err != nil {
+				return false
+			}
+			machine.SendZlp()
+			return true
+		}
+		if reportId == uint8(ReportVendor) && m.vendorSet != nil {
+			b, err := machine.ReceiveUSBControlPacket()
+			if err != nil {
+				return false
+			}
+			v := VendorFeatureData{}
+			if err := v.UnmarshalBinary(b[:]); err != nil {
+				return false
+			}
+			m.vendorSet(v)
+			machine.SendZlp()
+			return true
+		}
+	}
+	return false
+}
+
+func (m *PIDHandler) SetIdle(setup usb.Setup) bool {
+	machine.SendZlp()
+	return true
+}
+
+func (m *PIDHandler) SetProtocol(setup usb.Setup) bool {
+	machine.SendZlp()
+	return true
+}
+
+func (m *PIDHandler) SetupHandler(setup usb.Setup) bool {
+	switch setup.BmRequestType {
+	case usb.REQUEST_DEVICETOHOST_CLASS_INTERFACE:
+		switch setup.BRequest {
+		case usb.GET_REPORT:
+			return m.GetReport(setup)
+		case usb.GET_IDLE:
+			return m.GetIdle(setup)
+		case usb.GET_PROTOCOL:
+			return m.GetProtocol(setup)
+		}
+	case usb.REQUEST_HOSTTODEVICE_CLASS_INTERFACE:
+		switch setup.BRequest {
+		case usb.SET_REPORT:
+			return m.SetReport(setup)
+		case usb.SET_IDLE:
+			return m.SetIdle(setup)
+		case usb.SET_PROTOCOL:
+			return m.SetProtocol(setup)
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"diy-ffb-wheel/settings"
+	"diy-ffb-wheel/utils"
+)
+
+// RangeStep is how far the range combo changes the rotation range.
+const RangeStep = 90
+
+var (
+	errRange    = errors.New("rotation range out of bounds")
+	errProfile  = errors.New("no such profile")
+	errStrength = errors.New("strength out of range")
+)
+
+// lock2Lock returns the rotation range of the active profile in degrees.
+func lock2Lock() int32 {
+	if r := conf.Active().Range; r != 0 {
+		return int32(r)
+	}
+	return Lock2Lock
+}
+
+// applyProfile puts the active profile to use.
+func applyProfile() {
+	p := conf.Active()
+	applyRange(lock2Lock())
+	natural.Damper = int32(p.Damper)
+	natural.Friction = int32(p.Friction)
+	natural.Inertia = int32(p.Inertia)
+	natural.Spring = int32(p.Spring)
+}
+
+// applyRange rebuilds the axis mapping and the end stops for a lock to lock
+// rotation of lock degrees.
+func applyRange(lock int32) {
+	endStop.SetRange(lock)
+	max := endStop.Limit()
+	fit = utils.Map(-max, max, -32767, 32767)
+}
+
+// setRange changes the rotation range of the active profile and stores it.
+func setRange(lock int32) {
+	if lock < MinLock2Lock || lock > MaxLock2Lock {
+		log.Print(errRange)
+		return
+	}
+	applyRange(lock)
+	conf.Active().Range = uint16(lock)
+	saveWithTorqueOff(saveConf)
+	log.Printf("range: %d deg", lock)
+}
+
+// selectProfile makes profile n active and stores the choice.
+func selectProfile(n int32) {
+	if n < 0 || n >= settings.NumProfiles {
+		log.Print(errProfile)
+		return
+	}
+	conf.Profile = uint8(n)
+	applyProfile()
+	saveWithTorqueOff(saveConf)
+	log.Printf("profile: %d, range: %d deg", n, lock2Lock())
+}
+
+// setFeel changes the strength of the natural effect name in the active
+// profile and stores it.
+func setFeel(name string, percent int32) {
+	p := conf.Active()
+	var v *uint8
+	switch name {
+	case "damper":
+		v = &p.Damper
+	case "friction":
+		v = &p.Friction
+	case "inertia":
+		v = &p.Inertia
+	case "spring":
+		v = &p.Spring
+	default:
+		log.Printf("unknown effect: %s", name)
+		return
+	}
+	if percent < 0 || percent > 255 {
+		log.Print(errStrength)
+		return
+	}
+	*v = uint8(percent)
+	applyProfile()
+	saveWithTorqueOff(saveConf)
+	log.Printf("%s: %d%%", name, percent)
+}
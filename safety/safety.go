@@ -0,0 +1,151 @@
+// Package safety decides how much torque the wheel may put out, cutting it
+// when the host goes quiet, the e-stop is pressed or the wheel runs away.
+package safety
+
+import (
+	"strings"
+	"time"
+)
+
+// Fault is a set of latched trips.
+type Fault uint8
+
+const (
+	FaultHostSilent Fault = 1 << iota // host effects stopped being updated
+	FaultEStop                        // e-stop input was active
+	FaultRunaway                      // fast motion against the commanded torque
+)
+
+// FaultsStop are the trips that cut all torque until cleared. A silent
+// host only fades out the host effects.
+const FaultsStop = FaultEStop | FaultRunaway
+
+var faultNames = [...]string{"host silent", "e-stop", "runaway"}
+
+func (f Fault) String() string {
+	if f == 0 {
+		return "none"
+	}
+	var names []string
+	for i, name := range faultNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+type Config struct {
+	HostTimeout  time.Duration // silence after which host effects fade out
+	FadeTime     time.Duration // time to fade host effects out or back in
+	RunawaySpeed int32         // rpm
+	RunawayTime  time.Duration // how long a runaway must last to trip
+}
+
+var DefaultConfig = Config{
+	HostTimeout:  2 * time.Second,
+	FadeTime:     500 * time.Millisecond,
+	RunawaySpeed: 180,
+	RunawayTime:  200 * time.Millisecond,
+}
+
+// Input is what the supervisor looks at on each tick.
+type Input struct {
+	Now       time.Time
+	Received  uint32 // count of output reports from the host
+	HostForce int32  // force of the effects the host streams
+	EStop     bool   // e-stop input is active
+	Speed     int32  // rpm
+	Torque    int32  // torque commanded on the last tick
+}
+
+// Supervisor latches trips until Clear is called. The host has no start
+// of frame signal to watch since TinyGo doesn't expose USB SOF, so output
+// reports are the only sign the host is alive. They only arrive while a
+// game updates its effects, so silence is only a fault while streamed
+// effects, constant and ramp forces, push on the wheel. Conditions such as
+// springs are set once and play on without further reports.
+type Supervisor struct {
+	cfg       Config
+	latched   Fault
+	received  uint32
+	heard     time.Time
+	last      time.Time
+	host      int32 // share of host force in 1/256
+	runawayAt time.Time
+}
+
+func New(cfg Config) *Supervisor {
+	return &Supervisor{cfg: cfg, host: 256}
+}
+
+// Check updates the trips and returns the shares in 1/256 of the host
+// force and of the total torque that may be put out.
+func (s *Supervisor) Check(in Input) (host, total int32) {
+	if in.Received != s.received || s.heard.IsZero() {
+		s.received = in.Received
+		s.heard = in.Now
+	}
+	silent := in.HostForce != 0 && in.Now.Sub(s.heard) > s.cfg.HostTimeout
+	if silent {
+		s.latched |= FaultHostSilent
+	}
+	if in.EStop {
+		s.latched |= FaultEStop
+	}
+	if abs(in.Speed) > s.cfg.RunawaySpeed && in.Speed^in.Torque < 0 && in.Torque != 0 {
+		if s.runawayAt.IsZero() {
+			s.runawayAt = in.Now
+		} else if in.Now.Sub(s.runawayAt) >= s.cfg.RunawayTime {
+			s.latched |= FaultRunaway
+		}
+	} else {
+		s.runawayAt = time.Time{}
+	}
+	s.fade(in.Now, silent)
+	if s.latched&FaultsStop != 0 {
+		return s.host, 0
+	}
+	return s.host, 256
+}
+
+// fade moves the host share towards 0 while silent and back to 256 once
+// the host is heard again.
+func (s *Supervisor) fade(now time.Time, silent bool) {
+	dt := now.Sub(s.last)
+	s.last = now
+	if dt <= 0 || dt > s.cfg.FadeTime {
+		dt = s.cfg.FadeTime
+	}
+	step := int32(int64(256) * int64(dt) / int64(s.cfg.FadeTime))
+	if silent {
+		s.host -= step
+	} else {
+		s.host += step
+	}
+	switch {
+	case s.host < 0:
+		s.host = 0
+	case s.host > 256:
+		s.host = 256
+	}
+}
+
+// Faults returns the latched trips.
+func (s *Supervisor) Faults() Fault {
+	return s.latched
+}
+
+// Clear releases the latched trips. Conditions still present trip again on
+// the next Check.
+func (s *Supervisor) Clear() {
+	s.latched = 0
+	s.runawayAt = time.Time{}
+}
+
+func abs(n int32) int32 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
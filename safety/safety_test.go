@@ -0,0 +1,143 @@
+package safety
+
+import (
+	"testing"
+	"time"
+)
+
+var t0 = time.Unix(1000, 0)
+
+const tick = 10 * time.Millisecond
+
+// run checks in every tick from the time from up to to, and returns the
+// shares of the last check.
+func run(s *Supervisor, from, to time.Duration, in Input) (host, total int32) {
+	for d := from; d <= to; d += tick {
+		in.Now = t0.Add(d)
+		host, total = s.Check(in)
+	}
+	return host, total
+}
+
+func TestHostSilent(t *testing.T) {
+	s := New(DefaultConfig)
+	in := Input{Received: 1, HostForce: 1000}
+	if host, total := run(s, 0, 2*time.Second, in); host != 256 || total != 256 || s.Faults() != 0 {
+		t.Fatalf("within the timeout got shares %d, %d and %s", host, total, s.Faults())
+	}
+	run(s, 2*time.Second+tick, 2*time.Second+tick, in)
+	if s.Faults() != FaultHostSilent {
+		t.Fatalf("after the timeout got %s, want host silent", s.Faults())
+	}
+}
+
+// TestHostSilentConditions checks conditions alone, which the host sets
+// once, don't count as a silent host.
+func TestHostSilentConditions(t *testing.T) {
+	s := New(DefaultConfig)
+	if host, _ := run(s, 0, 10*time.Second, Input{Received: 1}); host != 256 || s.Faults() != 0 {
+		t.Errorf("got host share %d and %s, want 256 and none", host, s.Faults())
+	}
+}
+
+func TestHostFade(t *testing.T) {
+	s := New(DefaultConfig)
+	in := Input{Received: 1, HostForce: 1000}
+	run(s, 0, 2*time.Second, in)
+	// silent from 2010 ms, fading at 256 per 500 ms, 5 per tick
+	from := 2010 * time.Millisecond
+	for _, tc := range []struct {
+		at   time.Duration
+		want int32
+	}{
+		{2010 * time.Millisecond, 251},
+		{2250 * time.Millisecond, 131},
+		{2500 * time.Millisecond, 6},
+		{2600 * time.Millisecond, 0},
+	} {
+		host, total := run(s, from, tc.at, in)
+		from = tc.at + tick
+		if host < tc.want-2 || host > tc.want+2 || total != 256 {
+			t.Errorf("at %v got shares %d, %d, want %d, 256", tc.at, host, total, tc.want)
+		}
+	}
+	// heard again, the host effects fade back in while the trip stays
+	in.Received++
+	if host, _ := run(s, 2610*time.Millisecond, 2860*time.Millisecond, in); host < 126 || host > 130 {
+		t.Errorf("fading in got host share %d, want 128", host)
+	}
+	if host, _ := run(s, 2870*time.Millisecond, 3200*time.Millisecond, in); host != 256 {
+		t.Errorf("faded in got host share %d, want 256", host)
+	}
+	if s.Faults() != FaultHostSilent {
+		t.Errorf("got %s, want host silent latched", s.Faults())
+	}
+}
+
+func TestRunaway(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		speed, torque int32
+		last          time.Duration
+		trip          bool
+	}{
+		{"against torque", 200, -1000, 200 * time.Millisecond, true},
+		{"against torque, short", 200, -1000, 190 * time.Millisecond, false},
+		{"negative speed", -200, 1000, 200 * time.Millisecond, true},
+		{"with torque", 200, 1000, time.Second, false},
+		{"no torque", 200, 0, time.Second, false},
+		{"slow", 170, -1000, time.Second, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := New(DefaultConfig)
+			_, total := run(s, 0, tc.last, Input{Speed: tc.speed, Torque: tc.torque})
+			if trip := s.Faults()&FaultRunaway != 0; trip != tc.trip || trip != (total == 0) {
+				t.Errorf("got %s and total share %d, want trip %v", s.Faults(), total, tc.trip)
+			}
+		})
+	}
+}
+
+// TestRunawayRestarts checks a break in the runaway starts its timing
+// over.
+func TestRunawayRestarts(t *testing.T) {
+	s := New(DefaultConfig)
+	fast := Input{Speed: 200, Torque: -1000}
+	run(s, 0, 150*time.Millisecond, fast)
+	run(s, 160*time.Millisecond, 160*time.Millisecond, Input{Speed: 100, Torque: -1000})
+	run(s, 170*time.Millisecond, 360*time.Millisecond, fast)
+	if s.Faults() != 0 {
+		t.Fatalf("got %s after a break, want none", s.Faults())
+	}
+	run(s, 370*time.Millisecond, 370*time.Millisecond, fast)
+	if s.Faults() != FaultRunaway {
+		t.Errorf("got %s, want runaway", s.Faults())
+	}
+}
+
+func TestClear(t *testing.T) {
+	s := New(DefaultConfig)
+	if _, total := run(s, 0, 0, Input{EStop: true}); total != 0 || s.Faults() != FaultEStop {
+		t.Fatalf("got %s and total share %d, want e-stop and 0", s.Faults(), total)
+	}
+	// a condition still present trips again
+	s.Clear()
+	if s.Faults() != 0 {
+		t.Fatalf("got %s after clear, want none", s.Faults())
+	}
+	if _, total := run(s, tick, tick, Input{EStop: true}); total != 0 || s.Faults() != FaultEStop {
+		t.Errorf("held e-stop got %s and total share %d, want e-stop and 0", s.Faults(), total)
+	}
+	s.Clear()
+	if _, total := run(s, 2*tick, 2*tick, Input{}); total != 256 || s.Faults() != 0 {
+		t.Errorf("released e-stop got %s and total share %d, want none and 256", s.Faults(), total)
+	}
+	// a runaway underway starts over after clear
+	fast := Input{Speed: 200, Torque: -1000}
+	run(s, 3*tick, 3*tick+150*time.Millisecond, fast)
+	s.Clear()
+	run(s, 3*tick+160*time.Millisecond, 3*tick+300*time.Millisecond, fast)
+	if s.Faults() != 0 {
+		t.Errorf("got %s after clear, want the runaway timed from the clear", s.Faults())
+	}
+}
@@ -0,0 +1,159 @@
+// Package sched paces the control loop at a fixed rate and keeps timing
+// statistics for each stage of a tick.
+package sched
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const MaxRate = 1000 // Hz
+
+var ErrRate = errors.New("loop rate out of range")
+
+// Timing accumulates durations of one stage.
+type Timing struct {
+	Count uint32
+	Min   time.Duration
+	Max   time.Duration
+	Total time.Duration
+}
+
+func (t *Timing) Add(d time.Duration) {
+	if t.Count == 0 || d < t.Min {
+		t.Min = d
+	}
+	if d > t.Max {
+		t.Max = d
+	}
+	t.Total += d
+	t.Count++
+}
+
+func (t *Timing) Mean() time.Duration {
+	if t.Count == 0 {
+		return 0
+	}
+	return t.Total / time.Duration(t.Count)
+}
+
+// Loop runs ticks at a fixed period. Wait starts a tick and Mark closes
+// the current stage of it.
+type Loop struct {
+	period time.Duration
+	next   time.Time
+	start  time.Time
+	mark   time.Time
+	names  []string
+
+	Ticks    uint32
+	Overruns uint32 // ticks started a full period late or more
+	Jitter   Timing // lateness of tick starts
+	Busy     Timing // time from tick start to the last Mark
+	Stages   []Timing
+}
+
+// New returns a loop ticking at rate Hz with the named stages.
+func New(rate int, stages ...string) (*Loop, error) {
+	l := &Loop{
+		names:  stages,
+		Stages: make([]Timing, len(stages)),
+	}
+	if err := l.SetRate(rate); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Loop) SetRate(rate int) error {
+	if rate <= 0 || rate > MaxRate {
+		return ErrRate
+	}
+	l.period = time.Second / time.Duration(rate)
+	l.next = time.Time{}
+	return nil
+}
+
+func (l *Loop) Rate() int {
+	return int(time.Second / l.period)
+}
+
+func (l *Loop) Period() time.Duration {
+	return l.period
+}
+
+// Wait sleeps until the next tick is due. A tick that is a whole period
+// late counts as an overrun and restarts the schedule from now rather
+// than bursting to catch up.
+func (l *Loop) Wait() {
+	if d := l.due(time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+	l.tick(time.Now())
+}
+
+// due returns the time left until the next tick at now. The first call
+// starts the schedule.
+func (l *Loop) due(now time.Time) time.Duration {
+	if l.next.IsZero() {
+		l.next = now
+	}
+	return l.next.Sub(now)
+}
+
+// tick starts a tick at now.
+func (l *Loop) tick(now time.Time) {
+	late := now.Sub(l.next)
+	l.Jitter.Add(late)
+	if late >= l.period {
+		l.Overruns++
+		l.next = now
+	}
+	l.next = l.next.Add(l.period)
+	l.start, l.mark = now, now
+	l.Ticks++
+}
+
+// Mark ends stage i of the current tick.
+func (l *Loop) Mark(i int) {
+	l.end(i, time.Now())
+}
+
+func (l *Loop) end(i int, now time.Time) {
+	l.Stages[i].Add(now.Sub(l.mark))
+	l.mark = now
+	if i == len(l.Stages)-1 {
+		l.Busy.Add(now.Sub(l.start))
+	}
+}
+
+// Reset clears the statistics.
+func (l *Loop) Reset() {
+	l.Ticks, l.Overruns = 0, 0
+	l.Jitter, l.Busy = Timing{}, Timing{}
+	for i := range l.Stages {
+		l.Stages[i] = Timing{}
+	}
+}
+
+func (l *Loop) WriteTo(w io.Writer) (int64, error) {
+	n, err := fmt.Fprintf(w, "rate %d Hz, ticks %d, overruns %d\n",
+		l.Rate(), l.Ticks, l.Overruns)
+	total := int64(n)
+	line := func(name string, t *Timing) {
+		if err != nil {
+			return
+		}
+		n, err = fmt.Fprintf(w, "%-8s min %v mean %v max %v\n",
+			name, t.Min, t.Mean(), t.Max)
+		total += int64(n)
+	}
+	line("jitter", &l.Jitter)
+	line("busy", &l.Busy)
+	for i := range l.Stages {
+		line(l.names[i], &l.Stages[i])
+	}
+	return total, err
+}
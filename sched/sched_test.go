@@ -0,0 +1,114 @@
+package sched
+
+import (
+	"testing"
+	"time"
+)
+
+var t0 = time.Unix(1000, 0)
+
+const ms = time.Millisecond
+
+// wait does what Wait does when called at now, and returns the time the
+// tick started.
+func wait(l *Loop, now time.Time) time.Time {
+	if d := l.due(now); d > 0 {
+		now = now.Add(d)
+	}
+	l.tick(now)
+	return now
+}
+
+func TestWaitOverruns(t *testing.T) {
+	l, _ := New(100)
+	for _, tc := range []struct {
+		name     string
+		at       time.Duration
+		start    time.Duration
+		overruns uint32
+	}{
+		{"first", 0, 0, 0},
+		{"early", 3 * ms, 10 * ms, 0},
+		{"late", 29 * ms, 29 * ms, 0},
+		{"catching up", 33 * ms, 33 * ms, 0},
+		{"back on schedule", 35 * ms, 40 * ms, 0},
+		{"a period late", 60 * ms, 60 * ms, 1},
+		{"schedule from the overrun", 65 * ms, 70 * ms, 1},
+		{"just a period late", 90 * ms, 90 * ms, 2},
+		{"far behind", 200 * ms, 200 * ms, 3},
+		{"no burst", 201 * ms, 210 * ms, 3},
+	} {
+		start := wait(l, t0.Add(tc.at))
+		if start != t0.Add(tc.start) || l.Overruns != tc.overruns {
+			t.Fatalf("%s: tick at %v with %d overruns, want %v with %d",
+				tc.name, start.Sub(t0), l.Overruns, tc.start, tc.overruns)
+		}
+	}
+	if l.Ticks != 10 || l.Jitter.Min != 0 || l.Jitter.Max != 100*ms {
+		t.Errorf("%d ticks, jitter %v to %v, want 10, 0 to 100ms", l.Ticks, l.Jitter.Min, l.Jitter.Max)
+	}
+}
+
+func TestMark(t *testing.T) {
+	l, _ := New(100, "a", "b")
+	for _, tick := range []struct {
+		start time.Duration
+		marks [2]time.Duration
+	}{
+		{0, [2]time.Duration{2 * ms, 5 * ms}},
+		{10 * ms, [2]time.Duration{11 * ms, 14 * ms}},
+		{20 * ms, [2]time.Duration{28 * ms, 29 * ms}},
+	} {
+		wait(l, t0.Add(tick.start))
+		for i, d := range tick.marks {
+			l.end(i, t0.Add(d))
+		}
+	}
+	for _, tc := range []struct {
+		name          string
+		got           Timing
+		min, max, sum time.Duration
+	}{
+		{"a", l.Stages[0], ms, 8 * ms, 11 * ms},
+		{"b", l.Stages[1], ms, 3 * ms, 7 * ms},
+		{"busy", l.Busy, 4 * ms, 9 * ms, 18 * ms},
+	} {
+		if tc.got.Count != 3 || tc.got.Min != tc.min || tc.got.Max != tc.max || tc.got.Total != tc.sum {
+			t.Errorf("%s: %+v, want 3 of %v to %v totalling %v", tc.name, tc.got, tc.min, tc.max, tc.sum)
+		}
+	}
+	if mean := l.Busy.Mean(); mean != 6*ms {
+		t.Errorf("busy mean %v, want 6ms", mean)
+	}
+	l.Reset()
+	if l.Ticks != 0 || l.Busy != (Timing{}) || l.Stages[0] != (Timing{}) || l.Busy.Mean() != 0 {
+		t.Errorf("after reset %d ticks, busy %+v, stage %+v", l.Ticks, l.Busy, l.Stages[0])
+	}
+}
+
+func TestSetRate(t *testing.T) {
+	for _, rate := range []int{-1, 0, MaxRate + 1} {
+		if _, err := New(rate); err != ErrRate {
+			t.Errorf("New(%d) got %v, want ErrRate", rate, err)
+		}
+	}
+	l, _ := New(100)
+	wait(l, t0)
+	if err := l.SetRate(500); err != nil || l.Rate() != 500 || l.Period() != 2*ms {
+		t.Fatalf("got %v, rate %d, period %v, want 500 Hz and 2ms", err, l.Rate(), l.Period())
+	}
+	// the schedule starts over at the new rate
+	for _, tc := range []struct {
+		at, start time.Duration
+	}{
+		{ms, ms},
+		{ms + ms/2, 3 * ms},
+	} {
+		if start := wait(l, t0.Add(tc.at)); start != t0.Add(tc.start) {
+			t.Errorf("tick at %v, want %v", start.Sub(t0), tc.start)
+		}
+	}
+	if l.Overruns != 0 {
+		t.Errorf("%d overruns, want none", l.Overruns)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build tinygo
+
+package settings
+
+import "machine"
+
+// Flash stores a record in an erase block at the end of the flash data
+// area, which the program image never reaches. Block counts back from the
+// last erase block, which holds the settings.
+type Flash struct {
+	Block int64
+}
+
+func (f Flash) offset() int64 {
+	return machine.Flash.Size() - (f.Block+1)*machine.Flash.EraseBlockSize()
+}
+
+func (f Flash) Load(b []byte) error {
+	_, err := machine.Flash.ReadAt(b, f.offset())
+	return err
+}
+
+func (f Flash) Save(b []byte) error {
+	block := machine.Flash.EraseBlockSize()
+	if err := machine.Flash.EraseBlocks(f.offset()/block, 1); err != nil {
+		return err
+	}
+	n := machine.Flash.WriteBlockSize()
+	buf := make([]byte, (int64(len(b))+n-1)/n*n)
+	copy(buf, b)
+	_, err := machine.Flash.WriteAt(buf, f.offset())
+	return err
+}
@@ -0,0 +1,19 @@
+package settings
+
+// Memory is a Storage kept in RAM, for hosts without flash.
+type Memory struct {
+	data []byte
+}
+
+func (m *Memory) Load(b []byte) error {
+	if m.data == nil {
+		return ErrNoSettings
+	}
+	copy(b, m.data)
+	return nil
+}
+
+func (m *Memory) Save(b []byte) error {
+	m.data = append(m.data[:0], b...)
+	return nil
+}
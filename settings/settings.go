@@ -0,0 +1,170 @@
+// Package settings keeps the wheel configuration that survives a power
+// cycle.
+package settings
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+const (
+	magic   = 0x46464257 // "FFBW"
+	version = 3
+	Size    = 256
+
+	NumProfiles = 4
+)
+
+var (
+	ErrNoSettings = errors.New("settings: nothing stored")
+	ErrCorrupt    = errors.New("settings: checksum mismatch")
+)
+
+// Storage holds one settings record.
+type Storage interface {
+	Load(b []byte) error
+	Save(b []byte) error
+}
+
+type Settings struct {
+	Centered     bool  // CenterOffset holds a calibrated value
+	CenterOffset int32 // encoder count of the wheel center
+	Profile      uint8 // index of the active profile
+	Profiles     [NumProfiles]Profile
+}
+
+// Profile is a set of per game or per car settings.
+type Profile struct {
+	Range uint16 // lock to lock rotation in degrees, 0 for the default
+
+	// strengths of the natural effects in percent
+	Damper   uint8
+	Friction uint8
+	Inertia  uint8
+	Spring   uint8
+}
+
+// DefaultProfile is the feel the wheel had before profiles.
+var DefaultProfile = Profile{Damper: 100, Spring: 100}
+
+// Default returns the settings of a wheel that never stored any.
+func Default() Settings {
+	var s Settings
+	for i := range s.Profiles {
+		s.Profiles[i] = DefaultProfile
+	}
+	return s
+}
+
+// Active returns the active profile.
+func (s *Settings) Active() *Profile {
+	return &s.Profiles[s.Profile%NumProfiles]
+}
+
+func (s Settings) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, Size)
+	b = binary.LittleEndian.AppendUint32(b, magic)
+	b = append(b, version)
+	b = appendBool(b, s.Centered)
+	b = binary.LittleEndian.AppendUint32(b, uint32(s.CenterOffset))
+	b = append(b, s.Profile)
+	for _, p := range s.Profiles {
+		b = binary.LittleEndian.AppendUint16(b, p.Range)
+		b = append(b, p.Damper, p.Friction, p.Inertia, p.Spring)
+	}
+	b = binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+	return b, nil
+}
+
+func (s *Settings) UnmarshalBinary(b []byte) error {
+	// older records lack the fields added since and load with defaults
+	if len(b) < 5 || binary.LittleEndian.Uint32(b[0:4]) != magic || b[4] < 1 || b[4] > version {
+		return ErrNoSettings
+	}
+	*s = Default()
+	r := reader(b[5:])
+	s.Centered = r.bool()
+	s.CenterOffset = int32(r.uint32())
+	if b[4] >= 2 {
+		s.Profile = r.uint8() % NumProfiles
+		for i := range s.Profiles {
+			p := &s.Profiles[i]
+			p.Range = r.uint16()
+			if b[4] >= 3 {
+				p.Damper, p.Friction = r.uint8(), r.uint8()
+				p.Inertia, p.Spring = r.uint8(), r.uint8()
+			}
+		}
+	}
+	n := len(b) - len(r)
+	if len(r) < 4 || binary.LittleEndian.Uint32(r) != crc32.ChecksumIEEE(b[:n]) {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// Load reads the settings from st. The Default settings are returned with
+// the error when nothing valid is stored.
+func Load(st Storage) (Settings, error) {
+	b := make([]byte, Size)
+	if err := st.Load(b); err != nil {
+		return Default(), err
+	}
+	var s Settings
+	if err := s.UnmarshalBinary(b); err != nil {
+		return Default(), err
+	}
+	return s, nil
+}
+
+func Save(st Storage, s Settings) error {
+	b, err := s.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return st.Save(b)
+}
+
+func appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// reader consumes little endian fields, yielding zeros past the end.
+type reader []byte
+
+func (r *reader) uint8() uint8 {
+	if len(*r) < 1 {
+		return 0
+	}
+	v := (*r)[0]
+	*r = (*r)[1:]
+	return v
+}
+
+func (r *reader) bool() bool {
+	return r.uint8() != 0
+}
+
+func (r *reader) uint16() uint16 {
+	if len(*r) < 2 {
+		*r = nil
+		return 0
+	}
+	v := binary.LittleEndian.Uint16(*r)
+	*r = (*r)[2:]
+	return v
+}
+
+func (r *reader) uint32() uint32 {
+	if len(*r) < 4 {
+		*r = nil
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(*r)
+	*r = (*r)[4:]
+	return v
+}
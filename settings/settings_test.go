@@ -0,0 +1,107 @@
+package settings
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+func testSettings() Settings {
+	s := Default()
+	s.Centered = true
+	s.CenterOffset = -123456
+	s.Profile = 2
+	s.Profiles[1] = Profile{Range: 540, Damper: 50, Friction: 20, Inertia: 10, Spring: 0}
+	s.Profiles[2] = Profile{Range: 900, Damper: 255, Friction: 1, Inertia: 2, Spring: 3}
+	return s
+}
+
+// record returns a record of version v holding the fields, as older
+// firmware wrote it.
+func record(v uint8, fields ...byte) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, magic)
+	b = append(b, v)
+	b = append(b, fields...)
+	return binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+}
+
+func TestRoundTrip(t *testing.T) {
+	var m Memory
+	if s, err := Load(&m); !errors.Is(err, ErrNoSettings) || s != Default() {
+		t.Errorf("empty storage got %v, want the defaults and ErrNoSettings", err)
+	}
+	want := testSettings()
+	if err := Save(&m, want); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := Load(&m); err != nil || s != want {
+		t.Errorf("got %+v, %v, want %+v", s, err, want)
+	}
+}
+
+func TestMigration(t *testing.T) {
+	center := []byte{1, 0x40, 0xe2, 0x01, 0x00} // centered at 123456
+	for _, tc := range []struct {
+		name string
+		b    []byte
+		want func(s *Settings)
+	}{
+		{"v1", record(1, center...), func(s *Settings) {}},
+		{"v2", record(2, append(center, 1,
+			0x1c, 0x02, // 540
+			0x84, 0x03, // 900
+			0, 0,
+			0x68, 0x01, // 360
+		)...), func(s *Settings) {
+			s.Profile = 1
+			s.Profiles[0].Range = 540
+			s.Profiles[1].Range = 900
+			s.Profiles[3].Range = 360
+		}},
+		{"v2 profile wraps", record(2, append(center, 5, 0, 0, 0, 0, 0, 0, 0, 0)...), func(s *Settings) {
+			s.Profile = 1
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Memory{data: tc.b}
+			want := Default()
+			want.Centered, want.CenterOffset = true, 123456
+			tc.want(&want)
+			s, err := Load(&m)
+			if err != nil || s != want {
+				t.Fatalf("got %+v, %v, want %+v", s, err, want)
+			}
+			// stored again, it is read back as the current version
+			Save(&m, s)
+			if m.data[4] != version {
+				t.Errorf("saved as version %d, want %d", m.data[4], version)
+			}
+			if s, err := Load(&m); err != nil || s != want {
+				t.Errorf("saved again got %+v, %v, want %+v", s, err, want)
+			}
+		})
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(b []byte) []byte
+		err    error
+	}{
+		{"blank", func(b []byte) []byte { return make([]byte, Size) }, ErrNoSettings},
+		{"magic", func(b []byte) []byte { b[3]++; return b }, ErrNoSettings},
+		{"version 0", func(b []byte) []byte { b[4] = 0; return b }, ErrNoSettings},
+		{"newer version", func(b []byte) []byte { b[4] = version + 1; return b }, ErrNoSettings},
+		{"field", func(b []byte) []byte { b[6] ^= 1; return b }, ErrCorrupt},
+		{"checksum", func(b []byte) []byte { b[len(b)-1]++; return b }, ErrCorrupt},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-5] }, ErrCorrupt},
+	} {
+		b, _ := testSettings().MarshalBinary()
+		m := Memory{data: tc.modify(b)}
+		if s, err := Load(&m); !errors.Is(err, tc.err) || s != Default() {
+			t.Errorf("%s: got %v, want the defaults and %v", tc.name, err, tc.err)
+		}
+	}
+}